@@ -0,0 +1,107 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var appCPUP95Gauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "app_cpu_p95",
+		Help: "95th percentile of the CPU usage across the instances of an app",
+	},
+	[]string{"org", "space", "app", "app_guid"})
+
+var availabilityGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "app_availability_ratio",
+		Help: "Fraction of the desired instances of an app which are running",
+	},
+	[]string{"org", "space", "app", "app_guid"})
+
+var orgAppsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "org_apps",
+		Help: "Number of monitored apps in an org",
+	},
+	[]string{"org"})
+
+var orgInstancesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "org_instances",
+		Help: "Number of instances reported by the monitored apps in an org",
+	},
+	[]string{"org"})
+
+// updateOrgCounts exports the number of apps and instances per org,
+// dropping the series of orgs without monitored apps
+func (m *monitorState) updateOrgCounts() {
+	apps := make(map[string]int)
+	instances := make(map[string]int)
+	for _, app := range m.apps {
+		org := m.labelsFor(app).Org
+		apps[org]++
+		instances[org] += len(m.series[app.Guid].Instances)
+	}
+	for org := range m.countedOrgs {
+		if _, ok := apps[org]; !ok {
+			orgAppsGauge.DeleteLabelValues(org)
+			orgInstancesGauge.DeleteLabelValues(org)
+		}
+	}
+	m.countedOrgs = make(map[string]bool, len(apps))
+	for org, n := range apps {
+		orgAppsGauge.WithLabelValues(org).Set(float64(n))
+		orgInstancesGauge.WithLabelValues(org).Set(float64(instances[org]))
+		m.countedOrgs[org] = true
+	}
+}
+
+// countStates returns the number of running and crashed instances in stats
+func countStates(stats map[string]cfclient.AppStats) (running, crashed int) {
+	for _, s := range stats {
+		switch s.State {
+		case "RUNNING":
+			running++
+		case "CRASHED":
+			crashed++
+		}
+	}
+	return running, crashed
+}
+
+// percentile returns the nearest rank p-th percentile of values
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// availability returns the fraction of the desired instances of an app
+// which are running. It reports false when no instances are desired
+func availability(desired int, stats map[string]cfclient.AppStats) (float64, bool) {
+	if desired <= 0 {
+		return 0, false
+	}
+	running := 0
+	for _, s := range stats {
+		if s.State == "RUNNING" {
+			running++
+		}
+	}
+	return math.Min(float64(running)/float64(desired), 1), true
+}
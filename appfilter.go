@@ -0,0 +1,26 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"regexp"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// appFilter is the compiled -app-filter, nil when all apps are monitored
+var appFilter *regexp.Regexp
+
+// filterApps returns the apps whose name matches filter and the number
+// of apps dropped
+func filterApps(apps []cfclient.App, filter *regexp.Regexp) ([]cfclient.App, int) {
+	var kept []cfclient.App
+	for _, app := range apps {
+		if filter.MatchString(app.Name) {
+			kept = append(kept, app)
+		}
+	}
+	return kept, len(apps) - len(kept)
+}
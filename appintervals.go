@@ -0,0 +1,106 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// appIntervals maps app names or GUIDs to their own stats interval.
+// These apps are left out of the regular stats scrape
+var appIntervals map[string]time.Duration
+
+// parseAppIntervals parses a comma separated list of app=duration
+// overrides such as "checkout=5s,batch=5m"
+func parseAppIntervals(s string) (map[string]time.Duration, error) {
+	overrides := make(map[string]time.Duration)
+	for _, item := range splitList(s) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid app interval %q, expected app=duration", item)
+		}
+		app := strings.TrimSpace(parts[0])
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval for %s: %v", app, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("interval for %s must be positive", app)
+		}
+		overrides[app] = d
+	}
+	return overrides, nil
+}
+
+// intervalFor returns the own interval of app, matching its GUID
+// before its name
+func intervalFor(app cfclient.App, overrides map[string]time.Duration) (time.Duration, bool) {
+	if d, ok := overrides[app.Guid]; ok {
+		return d, true
+	}
+	d, ok := overrides[app.Name]
+	return d, ok
+}
+
+// newSchedules returns the time every distinct interval of overrides
+// is first due
+func newSchedules(overrides map[string]time.Duration, now time.Time) map[time.Duration]time.Time {
+	schedules := make(map[time.Duration]time.Time)
+	for _, d := range overrides {
+		schedules[d] = now.Add(d)
+	}
+	return schedules
+}
+
+// nextDue returns how long until the earliest of schedules is due
+func nextDue(schedules map[time.Duration]time.Time, now time.Time) time.Duration {
+	var next time.Time
+	for _, due := range schedules {
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if wait := next.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// scrapeDue fetches the stats of the apps whose schedule is due and
+// advances those schedules, skipping the runs that were missed
+func (m *monitorState) scrapeDue(now time.Time) {
+	due := make(map[time.Duration]bool)
+	for d, next := range m.schedules {
+		if next.After(now) {
+			continue
+		}
+		due[d] = true
+		for !next.After(now) {
+			next = next.Add(d)
+		}
+		m.schedules[d] = next
+	}
+	if !m.loggedIn || m.scrapeBackoff.backingOff() {
+		// The regular scrape detects when the CF API is back
+		return
+	}
+	var todo []cfclient.App
+	for _, app := range m.apps {
+		if d, ok := intervalFor(app, appIntervals); ok && due[d] && !isSelf(*selfExclusion, app, m.activeConfig) {
+			todo = append(todo, app)
+		}
+	}
+	for i, r := range fetchAll(m.client, todo, *concurrency, m.timeout.current) {
+		m.record(todo[i], r.stats, r.truncated, r.err)
+		m.observeFetch(todo[i], r.took)
+		if *logRate && r.err == nil {
+			m.recordLogRates(todo[i], r.logRates, r.logErr)
+		}
+	}
+}
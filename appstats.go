@@ -0,0 +1,63 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// instanceStats is the cached state of one app instance
+type instanceStats struct {
+	Org           string  `json:"org"`
+	Space         string  `json:"space"`
+	App           string  `json:"app"`
+	AppGUID       string  `json:"app_guid"`
+	InstanceIndex string  `json:"instance_index"`
+	CPU           float64 `json:"cpu"`
+	Memory        float64 `json:"memory_bytes"`
+	Disk          float64 `json:"disk_bytes"`
+	State         string  `json:"state"`
+	Updated       string  `json:"updated"`
+	AgeSeconds    float64 `json:"age_seconds"`
+}
+
+// instanceStatsHandler returns the cached stats of the instance given by
+// the guid and instance parameters without calling the CF API
+func instanceStatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		guid := req.URL.Query().Get("guid")
+		if guid == "" {
+			http.Error(w, "missing guid parameter", http.StatusBadRequest)
+			return
+		}
+		index := req.URL.Query().Get("instance")
+		if index == "" {
+			index = "0"
+		}
+		a, u, ok := usage.instance(guid, index)
+		if !ok {
+			http.Error(w, "instance not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, instanceStats{
+			Org:           a.Org,
+			Space:         a.Space,
+			App:           a.App,
+			AppGUID:       a.GUID,
+			InstanceIndex: index,
+			CPU:           u.CPU,
+			Memory:        u.Mem,
+			Disk:          u.Disk,
+			State:         u.State,
+			Updated:       a.Updated.UTC().Format(time.RFC3339),
+			AgeSeconds:    time.Since(a.Updated).Seconds(),
+		})
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUsername is the basic auth username when METRICS_USERNAME is unset
+const defaultUsername = "cfprom"
+
+// metricsUsername returns the accepted basic auth username
+func metricsUsername() string {
+	if u := strings.TrimSpace(os.Getenv("METRICS_USERNAME")); u != "" {
+		return u
+	}
+	return defaultUsername
+}
+
+// loadPasswords returns the accepted cfprom passwords from PASSWORD,
+// the comma separated PASSWORDS and the lines of PASSWORD_FILE. Accepting
+// several allows rotating the password without failing scrapes
+func loadPasswords() []string {
+	passwords := envPasswords()
+	if file := os.Getenv("PASSWORD_FILE"); file != "" {
+		lines, err := readPasswordFile(file)
+		if err != nil {
+			fmt.Printf("WARNING: unable to read PASSWORD_FILE: %v\n", err)
+		}
+		passwords = append(passwords, lines...)
+	}
+	return passwords
+}
+
+// envPasswords returns the passwords from PASSWORD and PASSWORDS
+func envPasswords() []string {
+	var passwords []string
+	if p := strings.TrimSpace(os.Getenv("PASSWORD")); p != "" {
+		passwords = append(passwords, p)
+	}
+	return append(passwords, splitList(os.Getenv("PASSWORDS"))...)
+}
+
+// readPasswordFile returns the non-empty lines of file
+func readPasswordFile(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var passwords []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			passwords = append(passwords, line)
+		}
+	}
+	return passwords, nil
+}
+
+// passwordSet holds the accepted passwords shared by all endpoints
+type passwordSet struct {
+	mu        sync.RWMutex
+	passwords []string
+	modTime   time.Time
+}
+
+// metricsPasswords is loaded at startup and reloaded by watch
+var metricsPasswords = &passwordSet{}
+
+// load sets the passwords from the environment and PASSWORD_FILE
+func (s *passwordSet) load() {
+	var modTime time.Time
+	if fi, err := os.Stat(os.Getenv("PASSWORD_FILE")); err == nil {
+		modTime = fi.ModTime()
+	}
+	passwords := loadPasswords()
+	s.mu.Lock()
+	s.passwords, s.modTime = passwords, modTime
+	s.mu.Unlock()
+}
+
+func (s *passwordSet) get() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.passwords
+}
+
+// watch checks file for changes every interval, so passwords can be
+// rotated without a restart
+func (s *passwordSet) watch(file string, interval time.Duration) {
+	for range time.Tick(interval) {
+		s.reload(file)
+	}
+}
+
+// reload replaces the passwords read from file when it changed. A file
+// which cannot be read or holds no passwords keeps the current ones
+func (s *passwordSet) reload(file string) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		fmt.Printf("Error checking PASSWORD_FILE, keeping the current passwords: %v\n", err)
+		return
+	}
+	s.mu.RLock()
+	changed := !fi.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	lines, err := readPasswordFile(file)
+	if err != nil || len(lines) == 0 {
+		fmt.Printf("PASSWORD_FILE is unreadable or empty, keeping the current passwords: %v\n", err)
+		return
+	}
+	s.mu.Lock()
+	s.passwords, s.modTime = append(envPasswords(), lines...), fi.ModTime()
+	s.mu.Unlock()
+	fmt.Printf("Reloaded %d passwords from %s\n", len(lines), file)
+}
+
+// validPassword reports whether p is one of passwords. Every password
+// is compared in constant time
+func validPassword(p string, passwords []string) bool {
+	valid := 0
+	for _, password := range passwords {
+		valid |= subtle.ConstantTimeCompare([]byte(p), []byte(password))
+	}
+	return valid == 1
+}
+
+// validUsername reports whether u is the configured username, compared in
+// constant time
+func validUsername(u, username string) bool {
+	return subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadPasswords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "passwords")
+	if err := ioutil.WriteFile(file, []byte("old\n\n  new  \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		password  string
+		passwords string
+		file      string
+		want      []string
+	}{
+		{"unset", "", "", "", nil},
+		{"whitespace only", "  ", "", "", nil},
+		{"single", " secret ", "", "", []string{"secret"}},
+		{"list", "", "a, b", "", []string{"a", "b"}},
+		{"file", "", "", file, []string{"old", "new"}},
+		{"missing file", "", "", filepath.Join(t.TempDir(), "missing"), nil},
+		{"all", "secret", "a, b", file, []string{"secret", "a", "b", "old", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD", tt.password)
+			t.Setenv("PASSWORDS", tt.passwords)
+			t.Setenv("PASSWORD_FILE", tt.file)
+			if got := loadPasswords(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadPasswords() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBasicAuthReloadedPasswords(t *testing.T) {
+	defer func(p []string) { metricsPasswords.passwords = p }(metricsPasswords.get())
+	file := filepath.Join(t.TempDir(), "passwords")
+	if err := ioutil.WriteFile(file, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PASSWORD", "")
+	t.Setenv("PASSWORDS", "")
+	t.Setenv("PASSWORD_FILE", file)
+	metricsPasswords.load()
+	h := basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(password string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.SetBasicAuth(defaultUsername, password)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	if got := status("old"); got != http.StatusOK {
+		t.Fatalf("old password: status %d, want %d", got, http.StatusOK)
+	}
+
+	steps := []struct {
+		name    string
+		content string
+		accept  []string
+		reject  []string
+	}{
+		{"add new", "old\nnew\n", []string{"old", "new"}, []string{"wrong"}},
+		{"remove old", "new\n", []string{"new"}, []string{"old"}},
+		{"emptied", "\n", []string{"new"}, []string{"old"}},
+	}
+	for i, step := range steps {
+		if err := ioutil.WriteFile(file, []byte(step.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Make the change visible on filesystems with coarse mtimes
+		mtime := time.Now().Add(time.Duration(i+1) * time.Second)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		metricsPasswords.reload(file)
+		for _, p := range step.accept {
+			if got := status(p); got != http.StatusOK {
+				t.Errorf("%s: password %s got status %d, want %d", step.name, p, got, http.StatusOK)
+			}
+		}
+		for _, p := range step.reject {
+			if got := status(p); got != http.StatusUnauthorized {
+				t.Errorf("%s: password %s got status %d, want %d", step.name, p, got, http.StatusUnauthorized)
+			}
+		}
+	}
+}
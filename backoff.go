@@ -0,0 +1,91 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Bounds of the delay between login retries after a failed refresh
+const (
+	loginRetryMin = time.Second
+	loginRetryMax = time.Minute
+)
+
+// loginBackoff schedules login retries with exponential backoff and jitter
+type loginBackoff struct {
+	delay time.Duration
+	retry <-chan time.Time
+}
+
+// failed schedules the next retry, doubling the delay up to loginRetryMax.
+// Half of the delay is randomized so replicas do not retry in lockstep
+func (b *loginBackoff) failed() {
+	switch {
+	case b.delay == 0:
+		b.delay = loginRetryMin
+	case b.delay < loginRetryMax:
+		b.delay *= 2
+		if b.delay > loginRetryMax {
+			b.delay = loginRetryMax
+		}
+	}
+	wait := b.delay/2 + time.Duration(rand.Int63n(int64(b.delay/2)+1))
+	fmt.Printf("Retrying login in %s\n", wait)
+	scrapeRetriesCounter.WithLabelValues("login").Inc()
+	b.retry = time.After(wait)
+}
+
+// reset stops retrying after a successful login
+func (b *loginBackoff) reset() {
+	b.delay = 0
+	b.retry = nil
+}
+
+// scrapeBackoff slows down the stats scrape while every app fails,
+// typically because the CF API is unreachable
+type scrapeBackoff struct {
+	failures int // Consecutive failed scrapes
+	skip     int // Stats ticks to skip before the next scrape
+}
+
+// scraped records the outcome of a scrape and returns the effective
+// interval, doubling interval for every consecutive failure up to max
+func (b *scrapeBackoff) scraped(ok bool, interval, max time.Duration) time.Duration {
+	if ok || max <= interval {
+		if b.failures > 0 {
+			fmt.Printf("Scrape succeeded, restoring the stats interval of %s\n", interval)
+		}
+		b.failures, b.skip = 0, 0
+		return interval
+	}
+	b.failures++
+	effective := interval
+	for i := 0; i < b.failures && effective < max; i++ {
+		effective *= 2
+	}
+	if effective > max {
+		effective = max
+	}
+	b.skip = int(effective/interval) - 1
+	fmt.Printf("%d consecutive scrapes failed, scraping every %s\n", b.failures, effective)
+	return effective
+}
+
+// wait reports whether the current stats tick is skipped, consuming it
+func (b *scrapeBackoff) wait() bool {
+	if b.skip > 0 {
+		b.skip--
+		return true
+	}
+	return false
+}
+
+// backingOff reports whether scrapes are currently slowed down
+func (b *scrapeBackoff) backingOff() bool {
+	return b.skip > 0
+}
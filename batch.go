@@ -0,0 +1,77 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// maxBatchSize is the largest page size accepted by the CF v2 API
+const maxBatchSize = 100
+
+// listApps pages through the apps matching query, batchSize at a time,
+// keeping those accepted by keep. Only the fields cfprom uses are kept,
+// so at most one page of full app resources is held in memory
+func listApps(client *cfclient.Client, query url.Values, batchSize int, keep func(cfclient.App) bool) ([]cfclient.App, error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("results-per-page", strconv.Itoa(batchSize))
+	next := "/v2/apps?" + q.Encode()
+	var apps []cfclient.App
+	for next != "" {
+		resp, err := client.DoRequest(client.NewRequest("GET", next))
+		if err != nil {
+			return nil, err
+		}
+		var page cfclient.AppResponse
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Resources {
+			if app := slimApp(r); keep == nil || keep(app) {
+				apps = append(apps, app)
+			}
+		}
+		next = page.NextUrl
+	}
+	return apps, nil
+}
+
+// slimApp returns the fields of an app resource used by cfprom
+func slimApp(r cfclient.AppResource) cfclient.App {
+	return cfclient.App{
+		Guid:              r.Meta.Guid,
+		Name:              r.Entity.Name,
+		SpaceGuid:         r.Entity.SpaceGuid,
+		State:             r.Entity.State,
+		Instances:         r.Entity.Instances,
+		Memory:            r.Entity.Memory,
+		DiskQuota:         r.Entity.DiskQuota,
+		Buildpack:         r.Entity.Buildpack,
+		DetectedBuildpack: r.Entity.DetectedBuildpack,
+		DockerImage:       r.Entity.DockerImage,
+	}
+}
+
+// batches splits apps into consecutive batches of at most size apps
+func batches(apps []cfclient.App, size int) [][]cfclient.App {
+	var b [][]cfclient.App
+	for len(apps) > size {
+		b = append(b, apps[:size])
+		apps = apps[size:]
+	}
+	if len(apps) > 0 {
+		b = append(b, apps)
+	}
+	return b
+}
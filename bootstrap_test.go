@@ -0,0 +1,85 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBootstrapLoginFailureKeepsClient(t *testing.T) {
+	srv := newFakeCF(t)
+	t.Setenv("CF_API", srv.URL)
+	t.Setenv("CF_SPACE_ID", "space-dev")
+	defer func(l bool) { *local = l }(*local)
+	*local = true
+
+	ch := make(chan config)
+	stop := make(chan struct{})
+	defer close(stop)
+	go monitor(ch, stop)
+
+	c, err := newConfig(bootstrapRequest{Username: "admin", Password: fakeCFPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	c.done = done
+	sendConfig(ch, c)
+	if err := <-done; err != nil {
+		t.Fatalf("initial login: %v", err)
+	}
+	old := getActiveClient()
+	if old == nil {
+		t.Fatal("no active client after the initial login")
+	}
+
+	for _, body := range []string{
+		`{"username":"admin","password":"wrong"}`,
+		`{"client_id":"cfprom","client_secret":"wrong"}`,
+	} {
+		w := httptest.NewRecorder()
+		bootstrapHandler(ch).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/bootstrap", strings.NewReader(body)))
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("%s: status = %d, want %d", body, w.Code, http.StatusBadGateway)
+		}
+		var resp bootstrapResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.ErrorCode != errLoginFailed {
+			t.Errorf("%s: error code = %q, want %q", body, resp.ErrorCode, errLoginFailed)
+		}
+		if got := getActiveClient(); got != old {
+			t.Errorf("%s: active client replaced after a failed bootstrap", body)
+		}
+		if got := getActiveConfig().Config.Password; got != fakeCFPassword {
+			t.Errorf("%s: active password = %q, want the previous one", body, got)
+		}
+	}
+}
+
+func TestBootstrapRequestMissing(t *testing.T) {
+	tests := []struct {
+		req  bootstrapRequest
+		want string
+	}{
+		{bootstrapRequest{Username: "admin", Password: "secret"}, ""},
+		{bootstrapRequest{ClientID: "cfprom", ClientSecret: "secret"}, ""},
+		{bootstrapRequest{ClientID: "cfprom"}, "missing client secret"},
+		{bootstrapRequest{ClientSecret: "secret"}, "missing client ID"},
+		{bootstrapRequest{Username: "admin"}, "missing password"},
+		{bootstrapRequest{Password: "secret"}, "missing username"},
+		{bootstrapRequest{}, "missing username and password, or client ID and secret"},
+	}
+	for _, tt := range tests {
+		if got := tt.req.missing(); got != tt.want {
+			t.Errorf("missing() of %+v = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const cfclientModule = "github.com/cloudfoundry-community/go-cfclient"
+
+var cfclientInfoGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cfprom_cfclient_info",
+		Help: "Version of go-cfclient and the CF API versions in use, always 1",
+	},
+	[]string{"version", "api"})
+
+// cfclientVersion returns the go-cfclient module version from the build info
+func cfclientVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == cfclientModule {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+func setCFClientInfo() {
+	api := "v2"
+	if *exportTasks {
+		api = "v2,v3"
+	}
+	cfclientInfoGauge.WithLabelValues(cfclientVersion(), api).Set(1)
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// lastScrape holds the unix time in nanoseconds of the last completed scrape
+	lastScrape int64
+
+	// scrapeNow asks the monitor for an immediate scrape
+	scrapeNow = make(chan struct{}, 1)
+
+	cacheHitsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cfprom_cache_hits_total",
+			Help: "Number of /metrics requests served from fresh cached values",
+		})
+	cacheMissesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cfprom_cache_misses_total",
+			Help: "Number of /metrics requests that found the cached values stale",
+		})
+)
+
+func markScraped(t time.Time) {
+	atomic.StoreInt64(&lastScrape, t.UnixNano())
+}
+
+func lastScraped() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&lastScrape))
+}
+
+// triggerScrape requests a scrape unless one is already pending
+func triggerScrape() {
+	atomic.AddInt64(&pendingScrapes, 1)
+	select {
+	case scrapeNow <- struct{}{}:
+	default:
+	}
+}
+
+// cacheHandler serves h and triggers a CF refresh when the values
+// collected by the last scrape are older than ttl
+func cacheHandler(h http.Handler, ttl time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if time.Since(lastScraped()) > ttl {
+			cacheMissesCounter.Inc()
+			triggerScrape()
+		} else {
+			cacheHitsCounter.Inc()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
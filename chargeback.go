@@ -0,0 +1,80 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Chargeback models
+const (
+	chargebackAllocated = "allocated"
+	chargebackUsed      = "used"
+)
+
+var (
+	allocatedGBHoursCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_allocated_memory_gb_hours_total",
+			Help: "Memory allocated to the running instances of an app integrated over time, in GB hours",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	usedGBHoursCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_used_memory_gb_hours_total",
+			Help: "Memory used by the instances of an app integrated over time, in GB hours",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+)
+
+func validChargeback(model string) error {
+	switch model {
+	case "", chargebackAllocated, chargebackUsed:
+		return nil
+	}
+	return fmt.Errorf("unknown chargeback model %q", model)
+}
+
+// chargebackMaxIntervals is the number of scrape intervals of an app
+// charged at most at once
+const chargebackMaxIntervals = 2
+
+// charge adds the memory of app since its previous charge to the
+// chargeback counter of model. The first charge of an app covers one
+// scrape interval. After a gap, such as a failed fetch, a backoff or
+// idle skipping, at most chargebackMaxIntervals are charged, since the
+// memory during the gap is not known
+func (m *monitorState) charge(model string, app cfclient.App, series appSeries, stats map[string]cfclient.AppStats, now time.Time) {
+	interval := intervals[groupStats]
+	if d, ok := intervalFor(app, appIntervals); ok {
+		interval = d
+	}
+	elapsed := interval
+	if last, ok := m.charged[app.Guid]; ok {
+		elapsed = now.Sub(last)
+	}
+	if max := chargebackMaxIntervals * interval; elapsed > max {
+		elapsed = max
+	}
+	m.charged[app.Guid] = now
+	hours := elapsed.Hours()
+	switch model {
+	case chargebackAllocated:
+		// Crashed and starting instances are not billed
+		running, _ := countStates(stats)
+		gb := float64(app.Memory) / 1024 * float64(running)
+		allocatedGBHoursCounter.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Add(gb * hours)
+	case chargebackUsed:
+		var bytes float64
+		for _, s := range stats {
+			bytes += float64(s.Stats.Usage.Mem)
+		}
+		usedGBHoursCounter.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Add(bytes / (1 << 30) * hours)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestChargeAllocatedRunningOnly(t *testing.T) {
+	m := newMonitorState()
+	app := cfclient.App{Guid: "guid-charge", Name: "billed", Memory: 2048}
+	series := appSeries{appLabels: appLabels{Org: "acme", Space: "dev", App: "billed"}, GUID: app.Guid}
+	counter := allocatedGBHoursCounter.WithLabelValues("acme", "dev", "billed", app.Guid)
+	defer allocatedGBHoursCounter.DeleteLabelValues("acme", "dev", "billed", app.Guid)
+
+	start := time.Now()
+	interval := intervals[groupStats]
+	m.charge(chargebackAllocated, app, series, testStats("RUNNING"), start)
+	before := counterValue(counter)
+	m.charge(chargebackAllocated, app, series, testStats("RUNNING", "CRASHED", "DOWN", "STARTING"), start.Add(interval))
+	if got, want := counterValue(counter)-before, 2*interval.Hours(); got != want {
+		t.Errorf("charged %v GB hours for one running 2 GB instance over one interval, want %v", got, want)
+	}
+}
+
+func TestChargeCapsGaps(t *testing.T) {
+	m := newMonitorState()
+	app := cfclient.App{Guid: "guid-gap", Name: "gap", Memory: 1024}
+	series := appSeries{appLabels: appLabels{Org: "acme", Space: "dev", App: "gap"}, GUID: app.Guid}
+	counter := allocatedGBHoursCounter.WithLabelValues("acme", "dev", "gap", app.Guid)
+	defer allocatedGBHoursCounter.DeleteLabelValues("acme", "dev", "gap", app.Guid)
+
+	start := time.Now()
+	interval := intervals[groupStats]
+	m.charge(chargebackAllocated, app, series, testStats("RUNNING"), start)
+	if got, want := counterValue(counter), interval.Hours(); got != want {
+		t.Errorf("first charge = %v GB hours, want one interval %v", got, want)
+	}
+	before := counterValue(counter)
+	m.charge(chargebackAllocated, app, series, testStats("RUNNING"), start.Add(time.Hour))
+	if got, want := counterValue(counter)-before, chargebackMaxIntervals*interval.Hours(); math.Abs(got-want) > 1e-12 {
+		t.Errorf("charged %v GB hours after an hour long gap, want %v", got, want)
+	}
+}
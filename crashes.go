@@ -0,0 +1,105 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var crashCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "instance_crashes_total",
+		Help: "Number of instance crashes reported by CF, by normalized reason",
+	},
+	[]string{"org", "space", "app", "app_guid", "reason"})
+
+var instanceStartsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "instance_starts_total",
+		Help: "Number of instances which appeared since the previous scrape of an app",
+	},
+	[]string{"org", "space", "app", "app_guid"})
+
+// crashReasonValues are the reasons returned by normalizeCrashReason
+var crashReasonValues = []string{"unknown", "oom", "health_check", "exit", "other"}
+
+// normalizeCrashReason maps the free form crash description of an
+// app.crash event to a bounded set of reasons
+func normalizeCrashReason(description string) string {
+	d := strings.ToLower(description)
+	switch {
+	case d == "":
+		return "unknown"
+	case strings.Contains(d, "out of memory"):
+		return "oom"
+	case strings.Contains(d, "health"):
+		return "health_check"
+	case strings.Contains(d, "exited"):
+		return "exit"
+	default:
+		return "other"
+	}
+}
+
+// trackStarts counts the instances of app which were not present in
+// its previous successful scrape. The first scrape of an app counts none
+func (m *monitorState) trackStarts(app cfclient.App, series appSeries, stats map[string]cfclient.AppStats) {
+	prev, seen := m.running[app.Guid]
+	current := make(map[string]bool, len(stats))
+	for i := range stats {
+		current[i] = true
+		if seen && !prev[i] {
+			instanceStartsCounter.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Inc()
+		}
+	}
+	m.running[app.Guid] = current
+}
+
+// updateCrashes counts the app.crash events of monitored apps
+// which occurred since the previous call
+func (m *monitorState) updateCrashes() error {
+	now := time.Now().UTC()
+	if m.lastCrashPoll.IsZero() {
+		m.lastCrashPoll = now
+		return nil
+	}
+	events, err := m.client.ListAppEventsByQuery(cfclient.AppCrash, []cfclient.AppEventQuery{
+		{Filter: cfclient.FilterTimestamp, Operator: ">", Value: m.lastCrashPoll.Format(time.RFC3339)},
+	})
+	if err != nil {
+		return err
+	}
+	m.lastCrashPoll = now
+
+	byGUID := make(map[string]cfclient.App, len(m.apps))
+	for _, app := range m.apps {
+		byGUID[app.Guid] = app
+	}
+	for _, e := range events {
+		app, ok := byGUID[e.Actee]
+		if !ok {
+			continue
+		}
+		l := m.labelsFor(app)
+		reason := normalizeCrashReason(e.MetaData.ExitDescription)
+		crashCounter.WithLabelValues(l.Org, l.Space, l.App, app.Guid, reason).Inc()
+		m.crashes[app.Guid]++
+	}
+	if *rates {
+		for _, app := range m.apps {
+			rate, ok := m.rates.observe("crashes/"+app.Guid, m.crashes[app.Guid], now)
+			if !ok {
+				continue
+			}
+			l := m.labelsFor(app)
+			crashRateGauge.WithLabelValues(l.Org, l.Space, l.App, app.Guid).Set(rate)
+		}
+	}
+	return nil
+}
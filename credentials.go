@@ -0,0 +1,88 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	minCredentialsInterval = 30 * time.Second
+	maxCredentialsBackoff  = 30 * time.Minute
+)
+
+// pollCredentials periodically fetches CF credentials from url and pushes
+// a new configuration to the monitor whenever they change. Credentials
+// the monitor fails to log in with are tried again on the next poll
+func pollCredentials(ch chan config, url string, interval time.Duration, current bootstrapRequest) {
+	if interval < minCredentialsInterval {
+		fmt.Printf("Credentials interval %s too short, using %s\n", interval, minCredentialsInterval)
+		interval = minCredentialsInterval
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	wait := interval
+
+	for {
+		time.Sleep(wait)
+
+		b, err := fetchCredentials(client, url)
+		if err != nil {
+			// Back off while the endpoint is unavailable
+			wait *= 2
+			if wait > maxCredentialsBackoff {
+				wait = maxCredentialsBackoff
+			}
+			fmt.Printf("Error fetching credentials: %v (retrying in %s)\n", err, wait)
+			continue
+		}
+		wait = interval
+		if *b == current {
+			continue
+		}
+		c, err := newConfig(*b)
+		if err != nil {
+			fmt.Printf("Error creating config from credentials: %v\n", err)
+			continue
+		}
+		fmt.Println("Credentials changed, reconfiguring")
+		done := make(chan error, 1)
+		c.done = done
+		sendConfig(ch, c)
+		select {
+		case err = <-done:
+		case <-time.After(bootstrapTimeout):
+			err = fmt.Errorf("timed out waiting for login")
+		}
+		if err != nil {
+			// New credentials often take a while to propagate in UAA
+			fmt.Printf("Error logging in with the new credentials, retrying in %s: %v\n", wait, err)
+			continue
+		}
+		current = *b
+	}
+}
+
+func fetchCredentials(client *http.Client, url string) (*bootstrapRequest, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var b bootstrapRequest
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return nil, err
+	}
+	if !b.valid() {
+		return nil, errors.New(b.missing())
+	}
+	return &b, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+)
+
+// dashboardPage renders the /summary of the monitored apps as a table,
+// refreshing it every 15 seconds
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cfprom</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; }
+.CRASHED, .DOWN { color: #c00; }
+</style>
+</head>
+<body>
+<h1>cfprom</h1>
+<table>
+<thead><tr><th>Org</th><th>Space</th><th>App</th><th>Instances</th><th>CPU %</th><th>Memory MB</th><th>State</th></tr></thead>
+<tbody id="apps"></tbody>
+</table>
+<p id="updated"></p>
+<script>
+function cell(row, text, cls) {
+  var td = row.insertCell();
+  td.textContent = text;
+  if (cls) td.className = cls;
+}
+function update() {
+  var xhr = new XMLHttpRequest();
+  xhr.open("GET", "summary" + window.location.search);
+  xhr.onload = function() {
+    if (xhr.status !== 200) return;
+    var tbody = document.getElementById("apps");
+    tbody.innerHTML = "";
+    JSON.parse(xhr.responseText).forEach(function(app) {
+      var row = tbody.insertRow();
+      cell(row, app.org);
+      cell(row, app.space);
+      cell(row, app.app);
+      cell(row, app.instances, "num");
+      cell(row, app.cpu.toFixed(1), "num");
+      cell(row, (app.memory_bytes / 1048576).toFixed(0), "num");
+      var states = Object.keys(app.states).sort();
+      cell(row, states.map(function(s) { return app.states[s] + " " + s; }).join(", "),
+        states.length === 1 ? states[0] : "");
+    });
+    document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  };
+  xhr.send();
+}
+update();
+setInterval(update, 15000);
+</script>
+</body>
+</html>
+`
+
+// dashboardHandler serves the dashboard at the root path only
+func dashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardPage))
+	})
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+)
+
+// appStatsHandler returns the raw CF stats of the app given by the guid parameter
+func appStatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		guid := req.URL.Query().Get("guid")
+		if guid == "" {
+			http.Error(w, "missing guid parameter", http.StatusBadRequest)
+			return
+		}
+		client := getActiveClient()
+		if client == nil {
+			http.Error(w, "not logged in", http.StatusServiceUnavailable)
+			return
+		}
+		stats, err := client.GetAppStats(guid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	})
+}
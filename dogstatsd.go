@@ -0,0 +1,85 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxDogStatsDPacket keeps packets below the common network MTU
+const maxDogStatsDPacket = 1432
+
+// dogStatsD sends gauges to a DogStatsD agent
+type dogStatsD struct {
+	conn     net.Conn
+	gatherer prometheus.Gatherer
+}
+
+func newDogStatsD(addr string, gatherer prometheus.Gatherer) (*dogStatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &dogStatsD{conn: conn, gatherer: gatherer}, nil
+}
+
+// send writes all current gauge values as DogStatsD packets,
+// mapping Prometheus labels to tags
+func (d *dogStatsD) send() {
+	mfs, err := d.gatherer.Gather()
+	if err != nil {
+		fmt.Printf("Error gathering metrics for DogStatsD: %v\n", err)
+		return
+	}
+	var buf bytes.Buffer
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_GAUGE {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			line := dogStatsDLine(mf.GetName(), m)
+			if buf.Len() > 0 && buf.Len()+len(line)+1 > maxDogStatsDPacket {
+				d.flush(&buf)
+			}
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(line)
+		}
+	}
+	d.flush(&buf)
+}
+
+func (d *dogStatsD) flush(buf *bytes.Buffer) {
+	if buf.Len() == 0 {
+		return
+	}
+	if _, err := d.conn.Write(buf.Bytes()); err != nil {
+		fmt.Printf("Error sending to DogStatsD: %v\n", err)
+	}
+	buf.Reset()
+}
+
+// tagValueReplacer replaces the characters which delimit the parts of a
+// DogStatsD datagram, so label values cannot corrupt it
+var tagValueReplacer = strings.NewReplacer(",", "_", "|", "_", ":", "_", "#", "_", "\n", "_")
+
+func dogStatsDLine(name string, m *dto.Metric) string {
+	line := fmt.Sprintf("%s:%g|g", name, m.GetGauge().GetValue())
+	if len(m.GetLabel()) == 0 {
+		return line
+	}
+	tags := make([]string, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		tags = append(tags, l.GetName()+":"+tagValueReplacer.Replace(l.GetValue()))
+	}
+	return line + "|#" + strings.Join(tags, ",")
+}
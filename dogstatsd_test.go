@@ -0,0 +1,23 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDogStatsDLineSanitizesTagValues(t *testing.T) {
+	label := func(name, value string) *dto.LabelPair { return &dto.LabelPair{Name: &name, Value: &value} }
+	value := 1.5
+	m := &dto.Metric{
+		Label: []*dto.LabelPair{label("org", "a,b|c"), label("app", "web:#1\nx")},
+		Gauge: &dto.Gauge{Value: &value},
+	}
+	if got, want := dogStatsDLine("cpu_usage", m), "cpu_usage:1.5|g|#org:a_b_c,app:web__1_x"; got != want {
+		t.Errorf("line = %q, want %q", got, want)
+	}
+}
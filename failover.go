@@ -0,0 +1,75 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// failoverThreshold is the number of consecutive failed logins against
+// the primary CF API after which the secondary is used
+const failoverThreshold = 3
+
+var activeEndpointGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cf_active_endpoint",
+		Help: "CF API endpoint currently in use, always 1",
+	},
+	[]string{"api", "role"})
+
+// login logs in with c. When a secondary CF API is configured the login
+// fails over to it after failoverThreshold consecutive failures against
+// the primary, and fails back as soon as the primary accepts a login again
+func (m *monitorState) login(c config) (*cfclient.Client, error) {
+	primary := c.Config
+	client, err := newLoggedInClient(&primary)
+	if err == nil {
+		if m.onSecondary {
+			fmt.Printf("Primary CF API %s is reachable again, failing back\n", primary.ApiAddress)
+		}
+		m.onSecondary = false
+		m.loginFailures = 0
+		setActiveEndpoint(primary.ApiAddress, "primary")
+		return client, nil
+	}
+	m.loginFailures++
+	if *cfAPISecondary == "" || m.loginFailures < failoverThreshold {
+		return nil, err
+	}
+	if !m.onSecondary {
+		fmt.Printf("Primary CF API failed %d consecutive logins, failing over to %s: %v\n", m.loginFailures, *cfAPISecondary, err)
+	}
+	secondary := c.Config
+	secondary.ApiAddress = *cfAPISecondary
+	client, err = newLoggedInClient(&secondary)
+	if err != nil {
+		return nil, err
+	}
+	m.onSecondary = true
+	setActiveEndpoint(secondary.ApiAddress, "secondary")
+	return client, nil
+}
+
+// newLoggedInClient creates a client for c and fetches its first token.
+// With client credentials cfclient.NewClient does not contact the UAA,
+// so a wrong secret would otherwise only fail on the first API call
+func newLoggedInClient(c *cfclient.Config) (*cfclient.Client, error) {
+	client, err := cfclient.NewClient(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.Config.TokenSource.Token(); err != nil {
+		return nil, fmt.Errorf("error fetching token: %v", err)
+	}
+	return client, nil
+}
+
+func setActiveEndpoint(api, role string) {
+	activeEndpointGauge.Reset()
+	activeEndpointGauge.WithLabelValues(api, role).Set(1)
+}
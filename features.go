@@ -0,0 +1,47 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// The experimental features which can be enabled through CFPROM_FEATURES
+const (
+	// featureCollectorModel serves the usage metrics from a collector
+	// which computes the age of the cached stats at scrape time
+	featureCollectorModel = "collector-model"
+	// featureV3API allows calls to the v3 CF API, such as the process
+	// stats used by -log-rate
+	featureV3API = "v3-api"
+)
+
+// featureSet holds the experimental features enabled through CFPROM_FEATURES
+type featureSet map[string]bool
+
+// features is parsed once at startup and consulted throughout
+var features = featureSet{}
+
+func parseFeatures(s string) featureSet {
+	f := featureSet{}
+	for _, name := range splitList(s) {
+		f[strings.ToLower(name)] = true
+	}
+	return f
+}
+
+func (f featureSet) enabled(name string) bool {
+	return f[name]
+}
+
+func (f featureSet) String() string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
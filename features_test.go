@@ -0,0 +1,50 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFeatures(t *testing.T) {
+	f := parseFeatures(" V3-API, collector-model,,")
+	for _, name := range []string{featureV3API, featureCollectorModel} {
+		if !f.enabled(name) {
+			t.Errorf("%s not enabled", name)
+		}
+	}
+	if f.enabled("log-cache") {
+		t.Error("log-cache enabled without being listed")
+	}
+	if got, want := f.String(), "collector-model,v3-api"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestUsageGaugesFollowCache(t *testing.T) {
+	if usage.gauges == nil {
+		t.Fatal("usage gauges not set without the collector-model feature")
+	}
+	labels := appLabels{Org: "acme", Space: "dev", App: "web"}
+	instance := func(i string) map[string]string {
+		return map[string]string{"org": "acme", "space": "dev", "app": "web", "app_guid": "guid-g", "instance_index": i}
+	}
+	usage.set(labels, "guid-g", map[string]instanceUsage{"0": {CPU: 1}, "1": {CPU: 2}}, time.Now())
+	usage.set(labels, "guid-g", map[string]instanceUsage{"0": {CPU: 3}}, time.Now())
+	if !hasSeries(usage.gauges.cpu, instance("0")) {
+		t.Error("cpu_usage of the remaining instance missing")
+	}
+	if hasSeries(usage.gauges.cpu, instance("1")) {
+		t.Error("cpu_usage of a removed instance still exported")
+	}
+	if got := gaugeValue(usage.gauges.cpu.WithLabelValues("acme", "dev", "web", "guid-g", "0")); got != 3 {
+		t.Errorf("cpu_usage = %v, want 3", got)
+	}
+	usage.delete("guid-g")
+	if hasSeries(usage.gauges.cpu, instance("0")) {
+		t.Error("cpu_usage of a deleted app still exported")
+	}
+}
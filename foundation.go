@@ -0,0 +1,37 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// constLabelGatherer adds a constant label to every sample gathered
+// from the wrapped Gatherer. The metrics are created before the flags
+// are parsed, so the label cannot be set through their ConstLabels.
+// Samples which already have the label keep their own value, as
+// overwriting it could make two samples identical
+type constLabelGatherer struct {
+	prometheus.Gatherer
+	name, value string
+}
+
+func (g constLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	name, value := g.name, g.value
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if _, ok := labelMap(m)[name]; ok {
+				continue
+			}
+			m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return mfs, err
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConstLabelGathererKeepsExistingLabel(t *testing.T) {
+	own := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_own", Help: "Without a foundation"})
+	labelled := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "test_labelled",
+		Help:        "With its own foundation",
+		ConstLabels: prometheus.Labels{"foundation": "other"},
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(own, labelled)
+
+	mfs, err := constLabelGatherer{registry, "foundation", "eu"}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"test_own": "eu", "test_labelled": "other"}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if len(m.Label) != 1 {
+				t.Errorf("%s has labels %v, want only foundation", mf.GetName(), m.Label)
+			}
+			if got := labelMap(m)["foundation"]; got != want[mf.GetName()] {
+				t.Errorf("foundation of %s = %q, want %q", mf.GetName(), got, want[mf.GetName()])
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type healthResponse struct {
+	Status     string `json:"status"`
+	LoggedIn   bool   `json:"logged_in"`
+	LastScrape string `json:"last_scrape,omitempty"`
+}
+
+// healthHandler reports healthy once the monitor is logged in and
+// completed at least one scrape
+func healthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resp := healthResponse{LoggedIn: getActiveClient() != nil}
+		scraped := atomic.LoadInt64(&lastScrape) != 0
+		if scraped {
+			resp.LastScrape = lastScraped().UTC().Format(time.RFC3339)
+		}
+		if !resp.LoggedIn || !scraped {
+			resp.Status = "UNAVAILABLE"
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		resp.Status = "OK"
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
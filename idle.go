@@ -0,0 +1,37 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// isIdle reports whether none of the instances report CPU or memory usage
+func isIdle(stats map[string]cfclient.AppStats) bool {
+	for _, s := range stats {
+		if s.Stats.Usage.CPU > 0 || s.Stats.Usage.Mem > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// skipIdle reports whether app has been idle long enough to skip it
+// in this scrape. Idle apps are still probed every probeEvery scrapes
+func (m *monitorState) skipIdle(app cfclient.App, idleAfter, probeEvery int) bool {
+	if idleAfter <= 0 || m.idle[app.Guid] < idleAfter {
+		return false
+	}
+	return probeEvery <= 0 || m.scrapes%probeEvery != 0
+}
+
+// trackIdle updates the number of consecutive idle scrapes of app
+func (m *monitorState) trackIdle(app cfclient.App, stats map[string]cfclient.AppStats) {
+	if isIdle(stats) {
+		m.idle[app.Guid]++
+	} else {
+		delete(m.idle, app.Guid)
+	}
+}
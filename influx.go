@@ -0,0 +1,79 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxHandler renders the metrics gathered from g in InfluxDB line
+// protocol. Labels become tags and sample values become fields
+func influxHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mfs, err := g.Gather()
+		if err != nil && len(mfs) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				fields := influxFields(mf.GetType(), m)
+				if fields == "" {
+					continue
+				}
+				bw.WriteString(influxEscaper.Replace(mf.GetName()))
+				for _, l := range m.Label {
+					if l.GetValue() == "" {
+						continue // Empty tag values are not allowed
+					}
+					bw.WriteString("," + influxEscaper.Replace(l.GetName()) + "=" + influxEscaper.Replace(l.GetValue()))
+				}
+				bw.WriteString(" " + fields + "\n")
+			}
+		}
+		bw.Flush()
+	})
+}
+
+// influxFields returns the field set of a sample, or an empty string
+// when the sample cannot be represented
+func influxFields(t dto.MetricType, m *dto.Metric) string {
+	var v float64
+	switch t {
+	case dto.MetricType_GAUGE:
+		v = m.GetGauge().GetValue()
+	case dto.MetricType_COUNTER:
+		v = m.GetCounter().GetValue()
+	case dto.MetricType_UNTYPED:
+		v = m.GetUntyped().GetValue()
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		return "count=" + strconv.FormatUint(h.GetSampleCount(), 10) + "i,sum=" + formatFloat(h.GetSampleSum())
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		return "count=" + strconv.FormatUint(s.GetSampleCount(), 10) + "i,sum=" + formatFloat(s.GetSampleSum())
+	default:
+		return ""
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ""
+	}
+	return "value=" + formatFloat(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var instanceKeyGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "instance_key_info",
+		Help: "Raw CF stats key of an instance whose key is not an integer, always 1",
+	},
+	[]string{"org", "space", "app", "app_guid", "instance_index", "key"})
+
+// normalizeInstances rekeys stats by numeric instance index. Integer keys
+// are kept. Other keys, which CF may report while rescheduling, are given
+// indexes after the largest integer key in sorted order and returned in
+// raw, mapping index to raw key
+func normalizeInstances(stats map[string]cfclient.AppStats) (normalized map[string]cfclient.AppStats, raw map[string]string) {
+	next := 0
+	var others []string
+	for k := range stats {
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 0 || strconv.Itoa(n) != k {
+			others = append(others, k)
+			continue
+		}
+		if n >= next {
+			next = n + 1
+		}
+	}
+	if len(others) == 0 {
+		return stats, nil
+	}
+	sort.Strings(others)
+	normalized = make(map[string]cfclient.AppStats, len(stats))
+	raw = make(map[string]string, len(others))
+	for k, s := range stats {
+		normalized[k] = s
+	}
+	for _, k := range others {
+		delete(normalized, k)
+		index := strconv.Itoa(next)
+		next++
+		normalized[index] = stats[k]
+		raw[index] = k
+	}
+	return normalized, raw
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestNormalizeInstances(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want map[string]string // Normalized index to raw key
+		raw  map[string]string
+	}{
+		{"empty", nil, map[string]string{}, nil},
+		{"sparse", []string{"0", "2"}, map[string]string{"0": "0", "2": "2"}, nil},
+		{"non-integer", []string{"a"}, map[string]string{"0": "a"}, map[string]string{"0": "a"}},
+		{"after largest", []string{"0", "3", "b", "a"}, map[string]string{"0": "0", "3": "3", "4": "a", "5": "b"}, map[string]string{"4": "a", "5": "b"}},
+		{"leading zero", []string{"1", "01"}, map[string]string{"1": "1", "2": "01"}, map[string]string{"2": "01"}},
+		{"negative", []string{"-1"}, map[string]string{"0": "-1"}, map[string]string{"0": "-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := make(map[string]cfclient.AppStats, len(tt.keys))
+			for _, k := range tt.keys {
+				stats[k] = cfclient.AppStats{State: k} // Remember the raw key
+			}
+			normalized, raw := normalizeInstances(stats)
+			got := make(map[string]string, len(normalized))
+			for i, s := range normalized {
+				got[i] = s.State
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalized = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("raw = %v, want %v", raw, tt.raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Collection groups, each fetched on its own interval
+const (
+	groupStats = "stats" // CPU, memory and other instance stats
+	groupApps  = "apps"  // Login refresh, app discovery and limits
+	groupOrgs  = "orgs"  // Org info
+	groupTasks = "tasks" // Task state and duration
+)
+
+// intervals holds the effective interval of every collection group
+var intervals = map[string]time.Duration{
+	groupStats: 15 * time.Second,
+	groupApps:  15 * time.Minute,
+	groupOrgs:  15 * time.Minute,
+	groupTasks: 15 * time.Second,
+}
+
+// parseIntervals applies a comma separated list of group=duration
+// overrides such as "orgs=1h,tasks=1m" to intervals
+func parseIntervals(s string) error {
+	for _, item := range splitList(s) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid interval %q, expected group=duration", item)
+		}
+		group := strings.TrimSpace(parts[0])
+		if _, ok := intervals[group]; !ok {
+			return fmt.Errorf("unknown collection group %q", group)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return fmt.Errorf("invalid interval for %s: %v", group, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("interval for %s must be positive", group)
+		}
+		intervals[group] = d
+	}
+	return nil
+}
+
+// applyIntervalFlags sets the stats and apps intervals from -scrape-interval
+// and -refresh-interval, or from SCRAPE_INTERVAL and REFRESH_INTERVAL when
+// the flags are not given, and checks that refreshes are not more frequent
+// than scrapes
+func applyIntervalFlags(scrape, refresh time.Duration) error {
+	for _, o := range []struct {
+		group, env string
+		d          time.Duration
+	}{
+		{groupStats, "SCRAPE_INTERVAL", scrape},
+		{groupApps, "REFRESH_INTERVAL", refresh},
+	} {
+		d := o.d
+		if v := os.Getenv(o.env); d == 0 && v != "" {
+			var err error
+			if d, err = time.ParseDuration(v); err != nil {
+				return fmt.Errorf("invalid %s: %v", o.env, err)
+			}
+			if d == 0 {
+				return fmt.Errorf("%s must be positive", o.env)
+			}
+		}
+		if d < 0 {
+			return fmt.Errorf("interval for %s must be positive", o.group)
+		}
+		if d > 0 {
+			intervals[o.group] = d
+		}
+	}
+	if intervals[groupApps] < intervals[groupStats] {
+		return fmt.Errorf("refresh interval %s is shorter than scrape interval %s", intervals[groupApps], intervals[groupStats])
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label sanitization modes
+const (
+	sanitizeNone  = "none"
+	sanitizeLower = "lower"
+	sanitizeSnake = "snake"
+)
+
+var labelInfoGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "app_label_info",
+		Help: "Raw org, space and app names behind sanitized label values, always 1",
+	},
+	[]string{"org", "space", "app", "app_guid", "raw_org", "raw_space", "raw_app"})
+
+// appLabels are the org, space and app label values of an app
+type appLabels struct {
+	Org   string
+	Space string
+	App   string
+}
+
+func validSanitizer(mode string) error {
+	switch mode {
+	case sanitizeNone, sanitizeLower, sanitizeSnake:
+		return nil
+	}
+	return fmt.Errorf("unknown label sanitization %q", mode)
+}
+
+// sanitize normalizes a label value according to mode. The snake mode
+// lowercases and replaces everything but letters and digits by underscores
+func sanitize(mode, s string) string {
+	switch mode {
+	case sanitizeLower:
+		return strings.ToLower(s)
+	case sanitizeSnake:
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return '_'
+		}, s)
+	}
+	return s
+}
+
+// rawLabelsFor returns the unsanitized label values of app
+func (m *monitorState) rawLabelsFor(app cfclient.App) appLabels {
+	info := m.spaceInfoFor(app)
+	return appLabels{Org: info.OrgName, Space: info.Name, App: app.Name}
+}
+
+// labelsFor returns the sanitized label values of app
+func (m *monitorState) labelsFor(app cfclient.App) appLabels {
+	return m.rawLabelsFor(app).sanitized(*sanitizeLabels)
+}
+
+func (l appLabels) sanitized(mode string) appLabels {
+	return appLabels{
+		Org:   sanitize(mode, l.Org),
+		Space: sanitize(mode, l.Space),
+		App:   sanitize(mode, l.App),
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logRateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_log_rate_bytes_per_second",
+			Help: "Rate at which an instance emits logs",
+		},
+		[]string{"org", "space", "app", "app_guid", "instance_index"})
+	logRateLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_log_rate_limit_bytes_per_second",
+			Help: "Log rate limit of an instance, -1 when unlimited",
+		},
+		[]string{"org", "space", "app", "app_guid", "instance_index"})
+	logQuotaExceededGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_log_quota_exceeded",
+			Help: "Whether an instance emits logs at its log rate limit, so its logs are being dropped",
+		},
+		[]string{"org", "space", "app", "app_guid", "instance_index"})
+)
+
+// instanceLogRate is the log rate and limit of an instance. Either is
+// nil when the CF API does not report it
+type instanceLogRate struct {
+	Rate  *float64
+	Limit *float64
+}
+
+// processStats is the part of a v3 process stats response holding the
+// log rates. CF versions before log rate limits omit these fields
+type processStats struct {
+	Resources []struct {
+		Index int `json:"index"`
+		Usage struct {
+			LogRate *float64 `json:"log_rate"`
+		} `json:"usage"`
+		LogRateLimit *float64 `json:"log_rate_limit"`
+	} `json:"resources"`
+}
+
+// fetchLogRates retrieves the log rates of the instances of the web
+// process of the app with guid, keyed by instance index
+func fetchLogRates(client *cfclient.Client, guid string, timeout time.Duration) (map[string]instanceLogRate, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v3/apps/%s/processes/web/stats", client.Config.ApiAddress, guid), nil)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	// client.Do hides the status of v3 errors, which do not decode as a
+	// CloudFoundryError, so use its authenticated HTTP client directly
+	req.Header.Set("User-Agent", client.Config.UserAgent)
+	resp, err := client.Config.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// The app has no web process or the CF API does not serve v3
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected process stats status for %s: %s", guid, resp.Status)
+	}
+	var stats processStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	rates := make(map[string]instanceLogRate, len(stats.Resources))
+	for _, r := range stats.Resources {
+		rates[strconv.Itoa(r.Index)] = instanceLogRate{Rate: r.Usage.LogRate, Limit: r.LogRateLimit}
+	}
+	return rates, nil
+}
+
+// recordLogRates exports the log rates of the instances of app which
+// were reported by its last stats, so truncated instances are skipped
+func (m *monitorState) recordLogRates(app cfclient.App, rates map[string]instanceLogRate, err error) {
+	series, ok := m.series[app.Guid]
+	if !ok {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error fetching log rates of %s: %v\n", app.Name, err)
+		scrapeErrorsCounter.WithLabelValues("log_rate").Inc()
+		return
+	}
+	for i := range series.Instances {
+		r, ok := rates[i]
+		if !ok || r.Rate == nil {
+			series.deleteLogRates(i)
+			continue
+		}
+		logRateGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i).Set(*r.Rate)
+		if r.Limit == nil {
+			continue
+		}
+		logRateLimitGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i).Set(*r.Limit)
+		exceeded := 0.0
+		if *r.Limit >= 0 && *r.Rate >= *r.Limit {
+			exceeded = 1
+		}
+		logQuotaExceededGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i).Set(exceeded)
+	}
+}
+
+// deleteLogRates removes the log rate series of instance i
+func (s appSeries) deleteLogRates(i string) {
+	logRateGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i)
+	logRateLimitGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i)
+	logQuotaExceededGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i)
+}
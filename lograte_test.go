@@ -0,0 +1,104 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestFetchLogRates(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   map[string][2]float64 // Index to rate and limit, -2 when absent
+		err    bool
+	}{
+		{"rates", http.StatusOK,
+			`{"resources":[{"index":0,"usage":{"log_rate":512},"log_rate_limit":1024},{"index":1,"usage":{"log_rate":64},"log_rate_limit":-1}]}`,
+			map[string][2]float64{"0": {512, 1024}, "1": {64, -1}}, false},
+		{"no log rate limits", http.StatusOK,
+			`{"resources":[{"index":0,"usage":{"cpu":0.1}}]}`,
+			map[string][2]float64{"0": {-2, -2}}, false},
+		{"not found", http.StatusNotFound, `{"errors":[{"code":10010,"title":"CF-ResourceNotFound"}]}`, map[string][2]float64{}, false},
+		{"server error", http.StatusInternalServerError, `{"errors":[]}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/v3/apps/guid-1/processes/web/stats" {
+					http.NotFound(w, req)
+					return
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			client := &cfclient.Client{Config: cfclient.Config{ApiAddress: srv.URL, HttpClient: srv.Client()}}
+
+			rates, err := fetchLogRates(client, "guid-1", 0)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if len(rates) != len(tt.want) {
+				t.Fatalf("got %d instances, want %d", len(rates), len(tt.want))
+			}
+			for i, want := range tt.want {
+				r, ok := rates[i]
+				if !ok {
+					t.Fatalf("instance %s missing", i)
+				}
+				for j, got := range []*float64{r.Rate, r.Limit} {
+					if (got == nil) != (want[j] == -2) || got != nil && *got != want[j] {
+						t.Errorf("instance %s field %d = %v, want %v", i, j, got, want[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecordLogRates(t *testing.T) {
+	m := newMonitorState()
+	m.spaces = map[string]spaceInfo{"space-dev": {Name: "dev", OrgName: "acme"}}
+	app := cfclient.App{Guid: "guid-1", Name: "web", SpaceGuid: "space-dev"}
+	m.record(app, testStats("RUNNING", "RUNNING"), false, nil)
+	defer m.forget(app.Guid)
+
+	rate := func(v float64) *float64 { return &v }
+	m.recordLogRates(app, map[string]instanceLogRate{
+		"0": {rate(1024), rate(1024)},
+		"1": {rate(10), rate(-1)},
+		"2": {rate(5), rate(1024)}, // Not in the app stats
+	}, nil)
+	instance := func(i string) map[string]string {
+		return map[string]string{"org": "acme", "space": "dev", "app": "web", "app_guid": "guid-1", "instance_index": i}
+	}
+	for i, want := range map[string]float64{"0": 1, "1": 0} {
+		if got := gaugeValue(logQuotaExceededGauge.WithLabelValues("acme", "dev", "web", "guid-1", i)); got != want {
+			t.Errorf("app_log_quota_exceeded of instance %s = %v, want %v", i, got, want)
+		}
+	}
+	if hasSeries(logRateGauge, instance("2")) {
+		t.Error("log rate exported for an instance missing from the app stats")
+	}
+
+	// Scaling down drops the log rates of the removed instance
+	m.record(app, testStats("RUNNING"), false, nil)
+	if hasSeries(logRateGauge, instance("1")) {
+		t.Error("log rate of a removed instance still exported")
+	}
+	if !hasSeries(logRateGauge, instance("0")) {
+		t.Error("log rate of a remaining instance dropped")
+	}
+}
@@ -5,13 +5,16 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry-community/go-cfclient"
@@ -22,93 +25,770 @@ import (
 )
 
 var (
-	addr     = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
-	cpuGauge = prometheus.NewGaugeVec(
+	addr                = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
+	local               = flag.Bool("local", false, "Run outside CF, taking the CF API, app and space from CF_API, CF_APP_ID and CF_SPACE_ID.")
+	stateFile           = flag.String("state-file", "", "File to persist bootstrapped credentials in, so they survive a restart.")
+	credentialsURL      = flag.String("credentials-url", "", "URL to poll for fresh CF credentials.")
+	credentialsInterval = flag.Duration("credentials-interval", 5*time.Minute, "How often to poll the credentials URL.")
+	spaces              = flag.String("spaces", "", "Comma separated GUIDs of the spaces to monitor instead of the space cfprom runs in. Defaults to CF_SPACES.")
+	spacesFile          = flag.String("spaces-file", "", "File listing the GUIDs of the spaces to monitor, reloaded when it changes. Overrides -spaces.")
+	spacesFileInterval  = flag.Duration("spaces-file-interval", 10*time.Second, "How often to check the spaces file for changes.")
+	target              = flag.String("target", "", "Org/space path of the space to monitor instead of the space cfprom runs in.")
+	foundationName      = flag.String("foundation-name", "", "Value of a foundation label added to all metrics. Empty omits the label.")
+	allApps             = flag.Bool("all-apps", false, "Monitor all apps in all orgs visible to the CF user.")
+	includeOrgs         = flag.String("include-orgs", "", "Comma separated org names or GUIDs to monitor in all-apps mode.")
+	excludeOrgs         = flag.String("exclude-orgs", "", "Comma separated org names or GUIDs to skip in all-apps mode.")
+	systemOrgs          = flag.String("system-orgs", "system", "Comma separated platform org names skipped in all-apps mode.")
+	includeSystemOrgs   = flag.Bool("include-system-orgs", false, "Also monitor the system orgs in all-apps mode.")
+	synthetic           = flag.Bool("synthetic", false, "Enable the /inject endpoint for testing alerts.")
+	enableDebug         = flag.Bool("enable-debug", false, "Enable debug metrics and endpoints.")
+	appFilterExpr       = flag.String("app-filter", "", "Regular expression app names must match to be monitored. Empty monitors all apps.")
+	selfExclusion       = flag.String("self-exclusion", selfByGUID, "How cfprom recognizes and skips its own app: guid, name or none.")
+	priorityApps        = flag.String("priority-apps", "", "Comma separated app names or GUIDs to scrape first.")
+	scrapeDeadline      = flag.Duration("scrape-deadline", 0, "Skip remaining non-priority apps when a scrape takes longer than this. 0 disables.")
+	dogstatsdAddress    = flag.String("dogstatsd-address", "", "DogStatsD agent address to send gauges to after each scrape.")
+	cfAPISecondary      = flag.String("cf-api-secondary", "", "CF API address to fail over to when logins against the primary keep failing.")
+	cfCACert            = flag.String("cf-ca-cert", "", "PEM file with CA certificates to trust for the CF API.")
+	skipSSLValidation   = flag.Bool("skip-ssl-validation", false, "Do not verify the CF API certificate. Defaults to CF_SKIP_SSL_VALIDATION.")
+	cfTimeout           = flag.Duration("cf-timeout", 30*time.Second, "Timeout of every CF API call. 0 disables.")
+	cfMaxIdleConns      = flag.Int("cf-max-idle-conns", 100, "Maximum number of idle connections to the CF API.")
+	cfMaxIdlePerHost    = flag.Int("cf-max-idle-conns-per-host", 32, "Maximum number of idle connections per CF API host.")
+	cfIdleConnTimeout   = flag.Duration("cf-idle-conn-timeout", 90*time.Second, "How long idle CF API connections are kept open.")
+	concurrency         = flag.Int("concurrency", 8, "Number of app stats to fetch in parallel.")
+	appTimeoutMin       = flag.Duration("app-timeout-min", time.Second, "Lower bound of the adaptive timeout of fetching the stats of one app.")
+	scrapeBackoffMax    = flag.Duration("scrape-backoff-max", 5*time.Minute, "Upper bound of the stats interval while every app fails to scrape. 0 disables the backoff.")
+	appTimeoutMax       = flag.Duration("app-timeout-max", 0, "Upper bound of the adaptive timeout of fetching the stats of one app. 0 disables the timeout.")
+	batchSize           = flag.Int("batch-size", maxBatchSize, "Number of apps to list per CF API page and to scrape per batch, at most 100.")
+	maxInstances        = flag.Int("max-instances", 0, "Maximum number of instances to report per app. 0 means no limit.")
+	exportTasks         = flag.Bool("tasks", false, "Export state and duration of tasks.")
+	cacheTTL            = flag.Duration("cache-ttl", 0, "Trigger a CF refresh when /metrics is requested and the cached values are older than this. 0 disables.")
+	appScrapeTiming     = flag.Bool("app-scrape-timing", false, "Export a stats fetch duration histogram per app.")
+	pushgatewayURL      = flag.String("pushgateway-url", "", "Pushgateway to push all metrics to after each scrape.")
+	pushgatewayJob      = flag.String("pushgateway-job", "cfprom", "Job name to push metrics under.")
+	pushgatewayInstance = flag.String("pushgateway-instance", "", "Set the instance label of pushed app samples: guid, guid-index, app or app-index. Empty leaves it unset.")
+	pushgatewayDelete   = flag.Bool("pushgateway-delete-on-shutdown", false, "Delete the pushed metrics on shutdown.")
+	exportOrgInfo       = flag.Bool("org-info", false, "Export the quota definition name of the monitored orgs.")
+	explicitTimestamps  = flag.Bool("explicit-timestamps", false, "Attach the scrape time to the samples written to -text-dump-file.")
+	textDumpFile        = flag.String("text-dump-file", "", "File to write all metrics to in the Prometheus text format after each scrape.")
+	idleAfter           = flag.Int("idle-after", 0, "Scrape apps less often after this many scrapes without CPU and memory usage. 0 disables.")
+	idleProbeEvery      = flag.Int("idle-probe-every", 20, "Scrape idle apps once every this many scrapes.")
+	scrapeInterval      = flag.Duration("scrape-interval", 0, "How often to fetch instance stats. Defaults to SCRAPE_INTERVAL or 15s.")
+	refreshInterval     = flag.Duration("refresh-interval", 0, "How often to refresh the login and the apps. Defaults to REFRESH_INTERVAL or 15m.")
+	intervalsFlag       = flag.String("intervals", "", "Comma separated group=duration collection intervals, e.g. orgs=1h,tasks=1m.")
+	appIntervalsFlag    = flag.String("app-intervals", "", "Comma separated app=duration stats intervals by app name or GUID, e.g. checkout=5s.")
+	startupJitter       = flag.Duration("startup-jitter", 0, "Delay the first login by a random duration up to this value.")
+	crashReasons        = flag.Bool("crash-reasons", false, "Count instance crashes by reason from CF app.crash events.")
+	rates               = flag.Bool("rates", false, "Also export per second rates derived from cumulative counters.")
+	sanitizeLabels      = flag.String("sanitize-labels", sanitizeNone, "Sanitization of org, space and app label values: none, lower or snake.")
+	appCPUP95           = flag.Bool("app-cpu-p95", false, "Export the 95th percentile CPU usage across the instances of each app.")
+	exportAvailability  = flag.Bool("availability", false, "Export the fraction of the desired instances of each app which are running.")
+	recoveryTime        = flag.Bool("recovery-time", false, "Export how long apps take until all instances run again after a restart of all instances.")
+	orgCounts           = flag.Bool("org-counts", false, "Export the number of monitored apps and instances per org.")
+	chargeback          = flag.String("chargeback", "", "Export memory GB hours for chargeback based on allocated or used memory. Empty disables.")
+	cpuSteal            = flag.Bool("cpu-steal", false, "Export an approximation of CPU steal based on co-located instances.")
+	logRate             = flag.Bool("log-rate", false, "Export the log rate and log rate limit of instances from the v3 process stats.")
+	metricNamespace     = flag.String("metric-namespace", "", "Namespace to prefix the usage, limit and instance count metrics of apps with, e.g. cfprom. Empty keeps the plain names.")
+	selfNamespace       = flag.String("self-metrics-namespace", "", "Namespace to prefix the Go and process metrics of cfprom itself with. Empty keeps the standard names.")
+	tlsCert             = flag.String("tls-cert", "", "Certificate file to serve HTTPS with.")
+	tlsKey              = flag.String("tls-key", "", "Private key file to serve HTTPS with.")
+	passwordsInterval   = flag.Duration("password-file-interval", 10*time.Second, "How often to check PASSWORD_FILE for changes.")
+	tlsReloadInterval   = flag.Duration("tls-reload-interval", time.Minute, "How often to check the certificate files for changes.")
+	failuresGauge       = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "cpu_usage",
-			Help: "CPU usage",
+			Name: "cfprom_consecutive_scrape_failures",
+			Help: "Number of consecutive failed stats scrapes of an app",
 		},
-		[]string{"org", "space", "app", "instance_index"})
-	memGauge = prometheus.NewGaugeVec(
+		[]string{"org", "space", "app", "app_guid"})
+	orgsGauge = prometheus.NewGauge(
 		prometheus.GaugeOpts{
-			Name: "mem_usage",
-			Help: "Memory usage",
+			Name: "cfprom_monitored_orgs",
+			Help: "Number of orgs monitored in all-apps mode",
+		})
+	scrapeAllocGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_scrape_alloc_bytes",
+			Help: "Bytes allocated during the last scrape",
+		})
+	heapInuseGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_scrape_heap_inuse_bytes",
+			Help: "Heap in use after the last scrape",
+		})
+	spaceResolvedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cfprom_space_resolved",
+			Help: "Whether the configured space GUID resolved (1) or not (0)",
+		},
+		[]string{"space_guid"})
+	truncatedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cfprom_instances_truncated",
+			Help: "Whether instances of an app were dropped because of the instance limit",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	reconfigBlockHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "cfprom_reconfig_block_seconds",
+			Help: "Time spent waiting for the monitor to accept a new configuration",
+		})
+	scrapeLagGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_scrape_lag_seconds",
+			Help: "Delay between the scheduled and actual start of the last scrape",
+		})
+	scrapeIntervalGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_scrape_interval_seconds",
+			Help: "Effective stats interval, longer than configured while scrapes fail",
+		})
+	scrapeErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cfprom_scrape_errors_total",
+			Help: "Number of failed CF API calls by operation: login, apps or stats",
 		},
-		[]string{"org", "space", "app", "instance_index"})
+		[]string{"operation"})
+	scrapeRetriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cfprom_scrape_retries_total",
+			Help: "Number of retried CF API calls by reason: rate_limit or login",
+		},
+		[]string{"reason"})
+	lastScrapeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_last_scrape_timestamp_seconds",
+			Help: "Time the last scrape completed, in seconds since the epoch",
+		})
+	scrapeDurationGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_scrape_duration_seconds",
+			Help: "Wall clock duration of the last scrape",
+		})
+	appScrapeHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "app_scrape_duration_seconds",
+			Help: "Time taken to fetch the stats of an app",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	orgInfoGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "org_info",
+			Help: "Information about a monitored org, always 1",
+		},
+		[]string{"org", "quota"})
+	configGenerationGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_config_generation",
+			Help: "Number of configurations received by the monitor",
+		})
+	appsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_monitored_apps",
+			Help: "Number of apps monitored",
+		})
+	filteredAppsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cfprom_filtered_apps",
+			Help: "Number of apps excluded by the org and app filters in the last discovery",
+		})
 )
 
 func init() {
-	prometheus.MustRegister(cpuGauge)
-	prometheus.MustRegister(memGauge)
+	prometheus.MustRegister(failuresGauge)
+	prometheus.MustRegister(orgsGauge)
+	prometheus.MustRegister(appsGauge)
+	prometheus.MustRegister(filteredAppsGauge)
+	prometheus.MustRegister(spaceResolvedGauge)
+	prometheus.MustRegister(truncatedGauge)
+	prometheus.MustRegister(reconfigBlockHistogram)
+	prometheus.MustRegister(scrapeLagGauge)
+	prometheus.MustRegister(scrapeIntervalGauge)
+	prometheus.MustRegister(scrapeErrorsCounter)
+	prometheus.MustRegister(scrapeRetriesCounter)
+	prometheus.MustRegister(lastScrapeGauge)
+	prometheus.MustRegister(scrapeDurationGauge)
+	prometheus.MustRegister(cfclientInfoGauge)
+	prometheus.MustRegister(configGenerationGauge)
+	prometheus.MustRegister(rateLimitedCounter)
+	prometheus.MustRegister(appInfoGauge)
+	prometheus.MustRegister(activeEndpointGauge)
+	prometheus.MustRegister(instanceStartsCounter)
+	prometheus.MustRegister(clockSkewGauge)
+	prometheus.MustRegister(instanceKeyGauge)
 }
 
+// The usage and limit gauges are created after the flags are parsed as
+// their names depend on -metric-namespace
+var (
+	usage                 *usageCollector
+	memQuotaGauge         *prometheus.GaugeVec
+	diskQuotaGauge        *prometheus.GaugeVec
+	instanceStateGauge    *prometheus.GaugeVec
+	memLimitGauge         *prometheus.GaugeVec
+	diskLimitGauge        *prometheus.GaugeVec
+	runningInstancesGauge *prometheus.GaugeVec
+	crashedInstancesGauge *prometheus.GaugeVec
+)
+
+// registerUsageGauges creates and registers the usage and limit gauges
+// with names prefixed by namespace
+func registerUsageGauges(namespace string) {
+	usage = newUsageCollector(namespace)
+	memQuotaGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "mem_quota",
+			Help:      "Memory quota of an instance",
+		},
+		[]string{"org", "space", "app", "app_guid", "instance_index"})
+	diskQuotaGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "disk_quota",
+			Help:      "Disk quota of an instance",
+		},
+		[]string{"org", "space", "app", "app_guid", "instance_index"})
+	instanceStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "instance_state",
+			Help:      "State of an instance such as RUNNING, CRASHED or STARTING, always 1",
+		},
+		[]string{"org", "space", "app", "app_guid", "instance_index", "state"})
+	memLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "app_memory_limit_bytes",
+			Help:      "Configured memory limit per app instance",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	diskLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "app_disk_limit_bytes",
+			Help:      "Configured disk limit per app instance",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	runningInstancesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "app_running_instances",
+			Help:      "Number of instances of an app in state RUNNING",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	crashedInstancesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "app_crashed_instances",
+			Help:      "Number of instances of an app in state CRASHED",
+		},
+		[]string{"org", "space", "app", "app_guid"})
+	if features.enabled(featureCollectorModel) {
+		prometheus.MustRegister(usage)
+	} else {
+		usage.gauges = newUsageGauges(namespace)
+		prometheus.MustRegister(usage.gauges.cpu)
+		prometheus.MustRegister(usage.gauges.mem)
+		prometheus.MustRegister(usage.gauges.disk)
+	}
+	for _, name := range []string{"cpu_usage", "mem_usage", "disk_usage", "mem_quota", "disk_quota", "instance_state", "app_running_instances", "app_crashed_instances"} {
+		statsFamilies[prometheus.BuildFQName(namespace, "", name)] = true
+	}
+	prometheus.MustRegister(memQuotaGauge)
+	prometheus.MustRegister(diskQuotaGauge)
+	prometheus.MustRegister(instanceStateGauge)
+	prometheus.MustRegister(memLimitGauge)
+	prometheus.MustRegister(diskLimitGauge)
+	prometheus.MustRegister(runningInstancesGauge)
+	prometheus.MustRegister(crashedInstancesGauge)
+}
+
+// cfHTTPClient is the HTTP client used for all CF API calls
+var cfHTTPClient *http.Client
+
+// afterScrape holds functions to call after each completed scrape
+var afterScrape []func()
+
 type config struct {
 	cfclient.Config
-	SpaceID string
-	AppID   string
+	SpaceID      string
+	SpaceIDs     []string
+	Target       string
+	AppID        string
+	AppName      string
+	AllApps      bool
+	IncludeOrgs  []string
+	ExcludeOrgs  []string
+	SystemOrgs   []string
+	PriorityApps []string
+	// startup marks the config cfprom started with, which may fail fast
+	startup bool
+	// done, when set, receives the outcome of the login with this config
+	done chan<- error
+}
+
+// spaceIDs returns the spaces to monitor outside all-apps mode: the
+// configured list, or else the single space from the CF environment
+func (c config) spaceIDs() []string {
+	if len(c.SpaceIDs) > 0 {
+		return c.SpaceIDs
+	}
+	return []string{c.SpaceID}
 }
 
+// setCredentials logs in with the UAA client of b when it has one,
+// otherwise with its username and password
+func (c *config) setCredentials(b bootstrapRequest) {
+	c.Config.Username, c.Config.Password = "", ""
+	c.Config.ClientID, c.Config.ClientSecret = "", ""
+	if b.clientCredentials() {
+		c.Config.ClientID, c.Config.ClientSecret = b.ClientID, b.ClientSecret
+		return
+	}
+	c.Config.Username, c.Config.Password = b.Username, b.Password
+}
+
+// hasCredentials reports whether c has credentials to log in with
+func (c config) hasCredentials() bool {
+	return c.Config.Password != "" || c.Config.ClientSecret != ""
+}
+
+// reply reports the outcome of the login with c to its sender
+func (c config) reply(err error) {
+	if c.done != nil {
+		c.done <- err
+	}
+}
+
+// bootstrapRequest holds CF credentials, either a username and password
+// or a UAA client ID and secret
 type bootstrapRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
 }
 
 type bootstrapResponse struct {
 	Bootstrapped bool   `json:"bootstrapped"`
 	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code,omitempty"`
 }
 
+// Machine-readable bootstrap error codes
+const (
+	errInvalidRequest     = "INVALID_REQUEST"
+	errMissingCredentials = "MISSING_CREDENTIALS"
+	errCFEnvUnavailable   = "CF_ENV_UNAVAILABLE"
+	errLoginFailed        = "LOGIN_FAILED"
+)
+
+// validNamespace matches the namespaces accepted by -metric-namespace
+var validNamespace = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// bootstrapTimeout bounds how long /bootstrap waits for the login
+const bootstrapTimeout = time.Minute
+
 func main() {
 	flag.Parse()
 
-	c := config{
-		cfclient.Config{
-			ApiAddress: getCFAPI(),
-			Username:   os.Getenv("CF_USERNAME"),
-			Password:   os.Getenv("CF_PASSWORD"),
-		},
-		"",
-		"",
+	if js := os.Getenv("CFPROM_CONFIG"); js != "" {
+		if err := applyConfigJSON(js); err != nil {
+			log.Fatalf("Error parsing CFPROM_CONFIG: %v", err)
+		}
 	}
-	appEnv, err := cfenv.Current()
+
+	if err := parseIntervals(*intervalsFlag); err != nil {
+		log.Fatalf("Error parsing intervals: %v", err)
+	}
+	if err := applyIntervalFlags(*scrapeInterval, *refreshInterval); err != nil {
+		log.Fatalf("Error parsing intervals: %v", err)
+	}
+	if *appIntervalsFlag != "" {
+		overrides, err := parseAppIntervals(*appIntervalsFlag)
+		if err != nil {
+			log.Fatalf("Error parsing app intervals: %v", err)
+		}
+		appIntervals = overrides
+	}
+	if *appFilterExpr != "" {
+		re, err := regexp.Compile(*appFilterExpr)
+		if err != nil {
+			log.Fatalf("Invalid -app-filter: %v", err)
+		}
+		appFilter = re
+	}
+	if *metricNamespace != "" && !validNamespace.MatchString(*metricNamespace) {
+		log.Fatalf("Invalid -metric-namespace %q, must be a valid metric name", *metricNamespace)
+	}
+	features = parseFeatures(os.Getenv("CFPROM_FEATURES"))
+	if len(features) > 0 {
+		fmt.Printf("Enabled features: %s\n", features)
+	}
+	if *logRate && !features.enabled(featureV3API) {
+		log.Fatalf("-log-rate uses the v3 CF API, enable it with CFPROM_FEATURES=%s", featureV3API)
+	}
+	registerUsageGauges(*metricNamespace)
+	if *local && os.Getenv("CF_API") == "" {
+		log.Fatal("CF_API must be set with -local")
+	}
+	if err := validSelfExclusion(*selfExclusion); err != nil {
+		log.Fatal(err)
+	}
+	if *appTimeoutMax > 0 {
+		if *appTimeoutMin <= 0 || *appTimeoutMin > *appTimeoutMax {
+			log.Fatalf("Invalid -app-timeout-min %s, must be positive and at most -app-timeout-max %s", *appTimeoutMin, *appTimeoutMax)
+		}
+		prometheus.MustRegister(appTimeoutGauge)
+	}
+	if *concurrency < 1 {
+		log.Fatalf("Invalid -concurrency %d, must be at least 1", *concurrency)
+	}
+	if *batchSize < 1 || *batchSize > maxBatchSize {
+		log.Fatalf("Invalid -batch-size %d, must be between 1 and %d", *batchSize, maxBatchSize)
+	}
+
+	if *target != "" {
+		if _, _, err := splitTarget(*target); err != nil {
+			log.Fatalf("Invalid -target: %v", err)
+		}
+	}
+
+	if err := validSanitizer(*sanitizeLabels); err != nil {
+		log.Fatal(err)
+	}
+	if *sanitizeLabels != sanitizeNone {
+		prometheus.MustRegister(labelInfoGauge)
+	}
+
+	httpClient, err := newCFHTTPClient(transportOptions{
+		CAFile:              *cfCACert,
+		SkipSSLValidation:   skipSSL(),
+		MaxIdleConns:        *cfMaxIdleConns,
+		MaxIdleConnsPerHost: *cfMaxIdlePerHost,
+		IdleConnTimeout:     *cfIdleConnTimeout,
+		Timeout:             *cfTimeout,
+	})
 	if err != nil {
-		fmt.Printf("Not running in CF. Exiting..\n")
-		return
+		log.Fatalf("Error loading CF CA certificate: %v", err)
 	}
-	c.AppID = appEnv.AppID
-	c.SpaceID = appEnv.SpaceID
+	cfHTTPClient = httpClient
+
+	setCFClientInfo()
+	if *appScrapeTiming {
+		prometheus.MustRegister(appScrapeHistogram)
+	}
+	if *cpuSteal {
+		prometheus.MustRegister(cpuStealGauge)
+	}
+	if *appCPUP95 {
+		prometheus.MustRegister(appCPUP95Gauge)
+	}
+	if *logRate {
+		prometheus.MustRegister(logRateGauge)
+		prometheus.MustRegister(logRateLimitGauge)
+		prometheus.MustRegister(logQuotaExceededGauge)
+	}
+	if *exportAvailability {
+		prometheus.MustRegister(availabilityGauge)
+	}
+	if *recoveryTime {
+		prometheus.MustRegister(recoveryGauge)
+	}
+	if *orgCounts {
+		prometheus.MustRegister(orgAppsGauge)
+		prometheus.MustRegister(orgInstancesGauge)
+	}
+	if err := validChargeback(*chargeback); err != nil {
+		log.Fatal(err)
+	}
+	switch *chargeback {
+	case chargebackAllocated:
+		prometheus.MustRegister(allocatedGBHoursCounter)
+	case chargebackUsed:
+		prometheus.MustRegister(usedGBHoursCounter)
+	}
+	if *crashReasons {
+		prometheus.MustRegister(crashCounter)
+		if *rates {
+			prometheus.MustRegister(crashRateGauge)
+		}
+	}
+	if *exportOrgInfo {
+		prometheus.MustRegister(orgInfoGauge)
+	}
+	if *exportTasks {
+		prometheus.MustRegister(taskStateGauge)
+		prometheus.MustRegister(taskDurationGauge)
+	}
+	if *enableDebug {
+		prometheus.MustRegister(scrapeAllocGauge)
+		prometheus.MustRegister(heapInuseGauge)
+	}
+
+	creds := bootstrapRequest{
+		Username:     os.Getenv("CF_USERNAME"),
+		Password:     os.Getenv("CF_PASSWORD"),
+		ClientID:     os.Getenv("CF_CLIENT_ID"),
+		ClientSecret: os.Getenv("CF_CLIENT_SECRET"),
+	}
+	c, err := newConfig(creds)
+	if err != nil {
+		log.Fatalf("Not running in CF, use -local to run elsewhere: %v", err)
+	}
+	if c.AppID == "" && *selfExclusion == selfByGUID && !*local {
+		fmt.Println("WARNING: CF environment has no application ID and CF_APP_ID is not set, cfprom will scrape itself")
+	}
+	if c.SpaceID == "" && len(c.SpaceIDs) == 0 && c.Target == "" && !c.AllApps {
+		fmt.Println("WARNING: CF environment has no space ID and CF_SPACE_ID is not set, no apps will be found")
+	}
+
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if *selfNamespace != "" {
+		gatherer = prometheus.Gatherers{gatherer, namespacedSelfMetrics(*selfNamespace)}
+	}
+	if *foundationName != "" {
+		gatherer = constLabelGatherer{gatherer, "foundation", *foundationName}
+	}
+
+	if *enableDebug {
+		afterScrape = append(afterScrape, metricTypeChecker(gatherer))
+	}
+
+	if *dogstatsdAddress != "" {
+		d, err := newDogStatsD(*dogstatsdAddress, gatherer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		afterScrape = append(afterScrape, d.send)
+	}
+
+	if *explicitTimestamps && *textDumpFile == "" {
+		log.Fatal("-explicit-timestamps requires -text-dump-file, the Pushgateway and /metrics do not accept timestamped samples")
+	}
+	if *textDumpFile != "" {
+		dumpGatherer := gatherer
+		if *explicitTimestamps {
+			dumpGatherer = timestampGatherer{dumpGatherer}
+		}
+		d := &textDump{*textDumpFile, dumpGatherer}
+		afterScrape = append(afterScrape, d.write)
+	}
+
+	if *pushgatewayURL != "" {
+		pushGatherer := gatherer
+		if err := validPushInstance(*pushgatewayInstance); err != nil {
+			log.Fatal(err)
+		}
+		if *pushgatewayInstance != "" {
+			pushGatherer = instanceLabelGatherer{pushGatherer, *pushgatewayInstance}
+		}
+		p := newPushGateway(*pushgatewayURL, *pushgatewayJob, pushGatherer)
+		afterScrape = append(afterScrape, p.push)
+		if *pushgatewayDelete {
+			beforeExit = append(beforeExit, p.delete)
+		} else {
+			beforeExit = append(beforeExit, p.push)
+		}
+	}
+
+	srv := &http.Server{Addr: *addr}
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go handleSignals(srv, stop, exited)
 
 	ch := make(chan config)
+	registerQueueGauges()
 
-	go monitor(ch)
+	go monitor(ch, stop)
 
-	ch <- c // Initial config
+	if *stateFile != "" {
+		// Bootstrapped credentials replace those from the environment
+		b, err := loadState(*stateFile)
+		switch {
+		case err == nil && b.valid():
+			fmt.Printf("Using the bootstrapped credentials from %s\n", *stateFile)
+			c.setCredentials(b)
+			creds = b
+		case err != nil && !os.IsNotExist(err):
+			fmt.Printf("WARNING: unable to read state file: %v\n", err)
+		}
+	}
+	if *spacesFile != "" {
+		guids, err := readSpacesFile(*spacesFile)
+		if err != nil {
+			log.Fatalf("Error reading spaces file: %v", err)
+		}
+		if len(guids) == 0 {
+			fmt.Println("Spaces file lists no spaces, ignoring it until it does")
+		}
+		go watchSpacesFile(ch, *spacesFile, *spacesFileInterval, c, c.SpaceIDs)
+	}
+	initial := c
+	initial.startup = true
+	sendConfig(ch, initial) // Initial config
+
+	if *credentialsURL != "" {
+		go pollCredentials(ch, *credentialsURL, *credentialsInterval, creds)
+	}
 
-	http.Handle("/metrics", basicAuth(promhttp.Handler()))
+	metricsPasswords.load()
+	if len(metricsPasswords.get()) == 0 && os.Getenv("PASSWORD") != "" {
+		fmt.Println("WARNING: PASSWORD only contains whitespace, authentication is disabled")
+	}
+	if file := os.Getenv("PASSWORD_FILE"); file != "" {
+		go metricsPasswords.watch(file, *passwordsInterval)
+	}
+	metricsHandler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	if *cacheTTL > 0 {
+		prometheus.MustRegister(cacheHitsCounter)
+		prometheus.MustRegister(cacheMissesCounter)
+		metricsHandler = cacheHandler(metricsHandler, *cacheTTL)
+	}
+	http.Handle("/metrics", basicAuth(metricsHandler))
 	http.Handle("/bootstrap", basicAuth(bootstrapHandler(ch)))
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	http.Handle("/config", basicAuth(configHandler()))
+	http.Handle("/influx", basicAuth(influxHandler(gatherer)))
+	http.Handle("/scrape-config", basicAuth(scrapeConfigHandler()))
+	http.Handle("/summary", basicAuth(summaryHandler()))
+	http.Handle("/appstats", basicAuth(instanceStatsHandler()))
+	http.Handle("/", basicAuth(dashboardHandler()))
+	http.Handle("/healthz", healthHandler())
+	if *synthetic {
+		http.Handle("/inject", basicAuth(injectHandler()))
+	}
+	if *enableDebug {
+		http.Handle("/debug/appstats", basicAuth(appStatsHandler()))
+	}
+	var serveErr error
+	if *tlsCert != "" && *tlsKey != "" {
+		reloader, err := newCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go reloader.watch(*tlsReloadInterval)
+		srv.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+		serveErr = srv.ListenAndServeTLS("", "")
+	} else {
+		serveErr = srv.ListenAndServe()
+	}
+	if serveErr != http.ErrServerClosed {
+		log.Fatal(serveErr)
+	}
+	<-exited
+}
+
+// newConfig builds a monitor configuration for the given CF credentials
+// using the app and space from the CF environment
+func newConfig(b bootstrapRequest) (config, error) {
+	c := config{
+		Config: cfclient.Config{
+			ApiAddress:        getCFAPI(),
+			HttpClient:        cfHTTPClient,
+			SkipSslValidation: skipSSL(),
+		},
+		Target:       *target,
+		AllApps:      *allApps,
+		IncludeOrgs:  splitList(*includeOrgs),
+		ExcludeOrgs:  splitList(*excludeOrgs),
+		PriorityApps: splitList(*priorityApps),
+	}
+	c.setCredentials(b)
+	if !*includeSystemOrgs {
+		c.SystemOrgs = splitList(*systemOrgs)
+	}
+	if c.SpaceIDs = splitList(*spaces); len(c.SpaceIDs) == 0 {
+		c.SpaceIDs = splitList(os.Getenv("CF_SPACES"))
+	}
+	if *spacesFile != "" {
+		if guids, err := readSpacesFile(*spacesFile); err == nil && len(guids) > 0 {
+			c.SpaceIDs = guids
+		} else if active := getActiveConfig(); len(active.SpaceIDs) > 0 {
+			c.SpaceIDs = active.SpaceIDs // Keep the spaces currently monitored
+		}
+	}
+	appEnv, err := cfenv.Current()
+	if err != nil && !*local {
+		return c, err
+	}
+	if err == nil {
+		c.AppID = appEnv.AppID
+		c.AppName = appEnv.Name
+		c.SpaceID = appEnv.SpaceID
+	}
+	// Some buildpack environments leave these empty
+	if c.AppID == "" {
+		c.AppID = os.Getenv("CF_APP_ID")
+	}
+	if c.SpaceID == "" {
+		c.SpaceID = os.Getenv("CF_SPACE_ID")
+	}
+	return c, nil
+}
+
+// skipSSL reports whether the CF API certificate should not be verified,
+// either by -skip-ssl-validation or CF_SKIP_SSL_VALIDATION
+func skipSSL() bool {
+	if *skipSSLValidation {
+		return true
+	}
+	skip, _ := strconv.ParseBool(os.Getenv("CF_SKIP_SSL_VALIDATION"))
+	return skip
+}
+
+// sendConfig hands c to the monitor, recording how long the send blocked
+func sendConfig(ch chan config, c config) {
+	start := time.Now()
+	atomic.AddInt64(&pendingConfigs, 1)
+	ch <- c
+	atomic.AddInt64(&pendingConfigs, -1)
+	reconfigBlockHistogram.Observe(time.Since(start).Seconds())
 }
 
 func (r *bootstrapRequest) valid() bool {
-	return r.Username != "" && r.Password != ""
+	return (r.Username != "" && r.Password != "") || (r.ClientID != "" && r.ClientSecret != "")
 }
 
-func basicAuth(h http.Handler) http.Handler {
-	password := os.Getenv("PASSWORD")
-	if password == "" { // Noop
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			h.ServeHTTP(w, r)
-		})
+// missing describes the credential r lacks, or is empty when r is valid
+func (r *bootstrapRequest) missing() string {
+	switch {
+	case r.valid():
+		return ""
+	case r.ClientID != "":
+		return "missing client secret"
+	case r.ClientSecret != "":
+		return "missing client ID"
+	case r.Username != "":
+		return "missing password"
+	case r.Password != "":
+		return "missing username"
 	}
+	return "missing username and password, or client ID and secret"
+}
+
+// clientCredentials reports whether r logs in as a UAA client, which
+// requires both its ID and secret
+func (r *bootstrapRequest) clientCredentials() bool {
+	return r.ClientID != "" && r.ClientSecret != ""
+}
+
+func basicAuth(h http.Handler) http.Handler {
+	username := metricsUsername()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passwords := metricsPasswords.get()
+		if len(passwords) == 0 { // Noop
+			h.ServeHTTP(w, r)
+			return
+		}
 		if u, p, ok := r.BasicAuth(); ok {
-			if u == "cfprom" && p == password {
+			// Evaluate both to not leak which one was wrong
+			validUser, validPass := validUsername(u, username), validPassword(p, passwords)
+			if validUser && validPass {
 				h.ServeHTTP(w, r)
 				return
 			}
 		}
 		if p, ok := r.URL.Query()["p"]; ok && len(p[0]) > 0 {
-			if p[0] == password {
+			if validPassword(p[0], passwords) {
 				h.ServeHTTP(w, r)
 				return
 			}
@@ -126,136 +806,84 @@ func getCFAPI() string {
 	if err != nil {
 		return cfclient.DefaultConfig().ApiAddress
 	}
+	if appEnv.CFAPI == "" {
+		fmt.Println("WARNING: CF environment has no API address and CF_API is not set, using the default")
+		return cfclient.DefaultConfig().ApiAddress
+	}
 	return appEnv.CFAPI
-
 }
 
 func bootstrapHandler(ch chan config) http.Handler {
-	var bootstrapped = false
-
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		var b bootstrapRequest
 		var resp bootstrapResponse
 
 		if req.Method == http.MethodGet {
-			resp.Bootstrapped = bootstrapped
+			resp.Bootstrapped = isBootstrapped()
 			resp.Status = "OK"
-			js, err := json.Marshal(resp)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
+			writeJSON(w, http.StatusOK, resp)
 			return
 		}
 		decoder := json.NewDecoder(req.Body)
 		err := decoder.Decode(&b)
 		defer req.Body.Close()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			resp.Bootstrapped = isBootstrapped()
+			resp.Status = "ERROR: " + err.Error()
+			resp.ErrorCode = errInvalidRequest
+			writeJSON(w, http.StatusInternalServerError, resp)
 			return
 		}
 		// Reconfigure
 		if b.valid() {
-			c := config{
-				cfclient.Config{
-					ApiAddress: getCFAPI(),
-					Username:   b.Username,
-					Password:   b.Password,
-				},
-				"",
-				"",
+			c, err := newConfig(b)
+			if err != nil {
+				resp.Bootstrapped = isBootstrapped()
+				resp.Status = "ERROR: " + err.Error()
+				resp.ErrorCode = errCFEnvUnavailable
+				writeJSON(w, http.StatusInternalServerError, resp)
+				return
+			}
+			done := make(chan error, 1)
+			c.done = done
+			sendConfig(ch, c) // Magic
+			select {
+			case err = <-done:
+			case <-time.After(bootstrapTimeout):
+				err = fmt.Errorf("timed out waiting for login")
 			}
-			appEnv, err := cfenv.Current()
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				// The monitor keeps using the previous configuration
+				resp.Bootstrapped = isBootstrapped()
+				resp.Status = "ERROR: " + err.Error()
+				resp.ErrorCode = errLoginFailed
+				writeJSON(w, http.StatusBadGateway, resp)
 				return
 			}
-			c.AppID = appEnv.AppID
-			c.SpaceID = appEnv.SpaceID
-			ch <- c // Magic
-			bootstrapped = true
-			resp.Bootstrapped = bootstrapped
+			setBootstrapped()
+			if *stateFile != "" {
+				if err := saveState(*stateFile, b); err != nil {
+					fmt.Printf("WARNING: unable to write state file: %v\n", err)
+				}
+			}
+			resp.Bootstrapped = isBootstrapped()
 			resp.Status = "OK"
 		} else {
-			resp.Status = "ERROR: missing username an/or password"
-		}
-		js, err := json.Marshal(resp)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			resp.Status = "ERROR: " + b.missing()
+			resp.ErrorCode = errMissingCredentials
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(js)
+		writeJSON(w, http.StatusOK, resp)
 	})
 }
 
-func monitor(ch chan config) {
-	var loggedIn = false
-	var client *cfclient.Client
-	var apps []cfclient.App
-	var activeConfig config
-	var spaceName = ""
-	var orgName = ""
-
-	check := time.NewTicker(time.Second * 15)
-	refresh := time.NewTicker(time.Second * 15 * 60)
-
-	for {
-		select {
-		case newConfig := <-ch:
-			// Configure
-			fmt.Println("Logging in after receiving configuration")
-			newClient, err := cfclient.NewClient(&newConfig.Config)
-			if err != nil {
-				fmt.Printf("Error logging in: %v\n", err)
-				continue
-			}
-			client = newClient
-			activeConfig = newConfig
-			fmt.Printf("Fetching apps in space: %s\n", activeConfig.SpaceID)
-			q := url.Values{}
-			q.Add("q", fmt.Sprintf("space_guid:%s", activeConfig.SpaceID))
-			apps, _ = client.ListAppsByQuery(q)
-			app := apps[0]
-			app, _ = client.GetAppByGuid(app.Guid)
-			space, _ := app.Space()
-			org, _ := space.Org()
-			spaceName = space.Name
-			orgName = org.Name
-			loggedIn = true
-		case <-refresh.C:
-			if activeConfig.Config.Password == "" {
-				fmt.Println("No configuration available during refresh")
-				continue
-			}
-			fmt.Println("Refreshing login")
-			newClient, err := cfclient.NewClient(&activeConfig.Config)
-			if err != nil {
-				fmt.Printf("Error refreshing login: %v\n", err)
-				continue
-			}
-			client = newClient
-			q := url.Values{}
-			q.Add("q", fmt.Sprintf("space_guid:%s", activeConfig.SpaceID))
-			apps, _ = client.ListAppsByQuery(q)
-		case <-check.C:
-			if !loggedIn {
-				continue
-			}
-			start := time.Now()
-			for _, app := range apps {
-				if app.Guid == activeConfig.AppID { // Skip self
-					continue
-				}
-				stats, _ := client.GetAppStats(app.Guid)
-				for i, s := range stats {
-					cpuGauge.WithLabelValues(orgName, spaceName, app.Name, i).Set(s.Stats.Usage.CPU * 100)
-					memGauge.WithLabelValues(orgName, spaceName, app.Name, i).Set(float64(s.Stats.Usage.Mem))
-				}
-			}
-			fmt.Printf("Fetching stats of %d apps took %s\n", len(apps), time.Since(start))
-		}
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
 }
@@ -0,0 +1,91 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	registerUsageGauges("")
+	os.Exit(m.Run())
+}
+
+// hasSeries reports whether c has a sample with all of labels
+func hasSeries(c prometheus.Collector, labels map[string]string) bool {
+	for _, m := range collectMetrics(c) {
+		got := labelMap(m)
+		match := true
+		for k, v := range labels {
+			if got[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// counterValue returns the current value of c
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	c.Write(&m)
+	return m.GetCounter().GetValue()
+}
+
+// gaugeValue returns the current value of g
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	g.Write(&m)
+	return m.GetGauge().GetValue()
+}
+
+// fakeCFPassword is the only password the fake CF API accepts
+const fakeCFPassword = "secret"
+
+// newFakeCF returns a CF API and UAA serving an empty space space-dev
+// in org acme, accepting only fakeCFPassword as password or client secret
+func newFakeCF(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/v2/info", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"authorization_endpoint":%q,"token_endpoint":%q}`, srv.URL, srv.URL)
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, req *http.Request) {
+		secret := req.FormValue("password")
+		if req.FormValue("grant_type") == "client_credentials" {
+			if _, secret, _ = req.BasicAuth(); secret == "" {
+				secret = req.FormValue("client_secret")
+			}
+		}
+		if secret != fakeCFPassword {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","token_type":"bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/v2/spaces/space-dev", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"metadata":{"guid":"space-dev"},"entity":{"name":"dev","organization_guid":"org-1","organization_url":"/v2/organizations/org-1"}}`)
+	})
+	mux.HandleFunc("/v2/organizations/org-1", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"metadata":{"guid":"org-1"},"entity":{"name":"acme"}}`)
+	})
+	mux.HandleFunc("/v2/apps", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"total_results":0,"total_pages":1,"resources":[]}`)
+	})
+	return srv
+}
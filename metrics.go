@@ -0,0 +1,50 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Every metric is declared with the constructor of its type. Use a
+// counter, named with a _total suffix, for values which only go up such
+// as crashes, restarts and requests, so rate() works on them. Use a gauge
+// for values which go up and down such as CPU and memory usage.
+
+// checkMetricTypes returns a problem for every metric family whose name
+// does not match its type
+func checkMetricTypes(mfs []*dto.MetricFamily) []string {
+	var problems []string
+	for _, mf := range mfs {
+		name := mf.GetName()
+		total := strings.HasSuffix(name, "_total")
+		switch {
+		case mf.GetType() == dto.MetricType_COUNTER && !total:
+			problems = append(problems, fmt.Sprintf("counter %s should end in _total", name))
+		case mf.GetType() != dto.MetricType_COUNTER && total:
+			problems = append(problems, fmt.Sprintf("%s %s ends in _total but is not a counter", strings.ToLower(mf.GetType().String()), name))
+		}
+	}
+	return problems
+}
+
+// metricTypeChecker logs the type problems of the metrics gathered from g
+// after the first scrape, when all metrics have samples
+func metricTypeChecker(g prometheus.Gatherer) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mfs, _ := g.Gather()
+			for _, p := range checkMetricTypes(mfs) {
+				fmt.Printf("WARNING: metric type: %s\n", p)
+			}
+		})
+	}
+}
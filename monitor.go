@@ -0,0 +1,491 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// monitorState is owned by the monitor goroutine
+type monitorState struct {
+	loggedIn      bool
+	client        *cfclient.Client
+	apps          []cfclient.App
+	activeConfig  config
+	spaces        map[string]spaceInfo
+	failures      map[string]int
+	series        map[string]appSeries
+	idle          map[string]int
+	scrapes       int
+	lastCrashPoll time.Time
+	crashes       map[string]float64
+	rates         rateTracker
+	loginFailures int
+	onSecondary   bool
+	running       map[string]map[string]bool
+	charged       map[string]time.Time
+	backoff       loginBackoff
+	timeout       *appTimeout
+	countedOrgs   map[string]bool
+	recovery      map[string]*recoveryState
+	schedules     map[time.Duration]time.Time
+	scrapeBackoff scrapeBackoff
+}
+
+// newMonitorState returns the state of a monitor which is not logged in
+func newMonitorState() *monitorState {
+	m := &monitorState{
+		failures: make(map[string]int),
+		series:   make(map[string]appSeries),
+		idle:     make(map[string]int),
+		crashes:  make(map[string]float64),
+		rates:    make(rateTracker),
+		running:  make(map[string]map[string]bool),
+		charged:  make(map[string]time.Time),
+		recovery: make(map[string]*recoveryState),
+		timeout:  newAppTimeout(*appTimeoutMin, *appTimeoutMax),
+	}
+	m.schedules = newSchedules(appIntervals, time.Now())
+	return m
+}
+
+// monitor runs the collection loop until a value is received on stop
+func monitor(ch chan config, stop <-chan struct{}) {
+	m := newMonitorState()
+	scrapeIntervalGauge.Set(intervals[groupStats].Seconds())
+
+	check := time.NewTicker(intervals[groupStats])
+	refresh := time.NewTicker(intervals[groupApps])
+	orgs := time.NewTicker(intervals[groupOrgs])
+	tasks := time.NewTicker(intervals[groupTasks])
+	defer check.Stop()
+	defer refresh.Stop()
+	defer orgs.Stop()
+	defer tasks.Stop()
+
+	// Apps with their own interval are scraped by a timer set to the
+	// earliest due schedule
+	var scheduled <-chan time.Time
+	var schedule *time.Timer
+	if len(m.schedules) > 0 {
+		schedule = time.NewTimer(nextDue(m.schedules, time.Now()))
+		defer schedule.Stop()
+		scheduled = schedule.C
+	}
+
+	// Delay the first login to spread load across replicas
+	var startup <-chan time.Time
+	var pending config
+	if *startupJitter > 0 {
+		delay := time.Duration(rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(int64(*startupJitter)))
+		fmt.Printf("Delaying first login by %s\n", delay)
+		startup = time.After(delay)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case newConfig := <-ch:
+			configGenerationGauge.Inc()
+			if startup != nil {
+				// The login is deferred, accept the config for now
+				newConfig.reply(nil)
+				newConfig.done = nil
+				pending = newConfig
+				continue
+			}
+			err := m.configure(newConfig)
+			newConfig.reply(err)
+			if err == nil {
+				// Warm up instead of waiting for the next tick
+				m.scrape()
+			}
+		case <-startup:
+			startup = nil
+			if pending.Config.ApiAddress != "" && m.configure(pending) == nil {
+				m.scrape()
+			}
+		case <-refresh.C:
+			m.refresh()
+		case <-m.backoff.retry:
+			m.refresh()
+		case tick := <-check.C:
+			if m.scrapeBackoff.wait() {
+				continue
+			}
+			scrapeLagGauge.Set(time.Since(tick).Seconds())
+			m.scrape()
+		case <-scrapeNow:
+			// One scrape serves all requests made since the last
+			atomic.StoreInt64(&pendingScrapes, 0)
+			if !m.scrapeBackoff.backingOff() {
+				m.scrape()
+			}
+		case <-scheduled:
+			m.scrapeDue(time.Now())
+			schedule.Reset(nextDue(m.schedules, time.Now()))
+		case <-orgs.C:
+			if m.loggedIn && *exportOrgInfo {
+				m.updateOrgInfo()
+			}
+		case <-tasks.C:
+			if m.loggedIn && *exportTasks {
+				if err := m.updateTasks(); err != nil {
+					fmt.Printf("Error fetching tasks: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+// configure logs in using newConfig and discovers the apps to monitor.
+// When the login or resolving the target fails the current client,
+// config and apps are kept and the error is returned. Only a target of
+// the startup config that does not resolve is fatal
+func (m *monitorState) configure(newConfig config) error {
+	fmt.Println("Logging in after receiving configuration")
+	newClient, err := m.login(newConfig)
+	if err != nil {
+		fmt.Printf("Error logging in, keeping the current configuration: %v\n", err)
+		scrapeErrorsCounter.WithLabelValues("login").Inc()
+		return err
+	}
+	newConfig.done = nil
+	if newConfig.Target != "" && !newConfig.AllApps {
+		guid, err := resolveTarget(newClient, newConfig.Target)
+		if err != nil {
+			if newConfig.startup {
+				log.Fatalf("Unable to resolve target %s: %v", newConfig.Target, err)
+			}
+			fmt.Printf("Unable to resolve target %s, keeping the current configuration: %v\n", newConfig.Target, err)
+			return err
+		}
+		fmt.Printf("Resolved target %s to space %s\n", newConfig.Target, guid)
+		newConfig.SpaceID = guid
+	}
+	newConfig.startup = false
+	m.client = newClient
+	m.activeConfig = newConfig
+	m.spaces = nil
+	setActive(m.client, m.activeConfig)
+	m.loggedIn = true
+	if err := m.discover(); err != nil {
+		fmt.Printf("Error fetching apps: %v\n", err)
+		scrapeErrorsCounter.WithLabelValues("apps").Inc()
+	}
+	if *exportOrgInfo {
+		m.updateOrgInfo()
+	}
+	if *crashReasons && m.lastCrashPoll.IsZero() {
+		m.lastCrashPoll = time.Now().UTC()
+	}
+	return nil
+}
+
+// refresh renews the login and the list of apps to monitor
+func (m *monitorState) refresh() {
+	if !m.activeConfig.hasCredentials() {
+		fmt.Println("No configuration available during refresh")
+		return
+	}
+	fmt.Println("Refreshing login")
+	newClient, err := m.login(m.activeConfig)
+	if err != nil {
+		fmt.Printf("Error refreshing login: %v\n", err)
+		scrapeErrorsCounter.WithLabelValues("login").Inc()
+		m.backoff.failed()
+		return
+	}
+	m.backoff.reset()
+	m.client = newClient
+	setActive(m.client, m.activeConfig)
+	if !m.activeConfig.AllApps {
+		// In all-apps mode discover resolves all names anyway
+		m.refreshSpaceNames()
+	}
+	if err := m.discover(); err != nil {
+		fmt.Printf("Error refreshing apps: %v\n", err)
+		scrapeErrorsCounter.WithLabelValues("apps").Inc()
+		return
+	}
+	if *crashReasons {
+		if err := m.updateCrashes(); err != nil {
+			fmt.Printf("Error fetching crash events: %v\n", err)
+		}
+	}
+}
+
+// discover lists the apps to monitor. It is independent of the login
+// so a failed listing can be retried without logging in again
+func (m *monitorState) discover() error {
+	if m.activeConfig.AllApps {
+		fmt.Println("Fetching apps in all orgs")
+		apps, spaces, err := discoverAll(m.client, m.activeConfig)
+		if err != nil {
+			return err
+		}
+		m.apps, m.spaces = apps, spaces
+	} else {
+		var apps []cfclient.App
+		for _, guid := range m.activeConfig.spaceIDs() {
+			spaceApps, err := m.discoverSpace(guid)
+			if err != nil {
+				return err
+			}
+			apps = append(apps, spaceApps...)
+		}
+		m.apps = apps
+		filteredAppsGauge.Set(0)
+	}
+	if appFilter != nil {
+		// On top of the apps dropped by the org filters
+		apps, dropped := filterApps(m.apps, appFilter)
+		m.apps = apps
+		filteredAppsGauge.Add(float64(dropped))
+	}
+	m.prune()
+	m.updateLimits()
+	m.updateAppInfo()
+	setDiscovered(m.spaces, len(m.apps))
+	return nil
+}
+
+// discoverSpace lists the apps in the space guid and resolves its names
+func (m *monitorState) discoverSpace(guid string) ([]cfclient.App, error) {
+	space, resolved := resolveSpace(m.client, guid)
+	fmt.Printf("Fetching apps in space: %s\n", guid)
+	q := url.Values{}
+	q.Add("q", fmt.Sprintf("space_guid:%s", guid))
+	apps, err := listApps(m.client, q, *batchSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := m.spaces[guid]; !ok && resolved {
+		org, err := space.Org()
+		if err != nil {
+			return nil, fmt.Errorf("resolving org of space %s: %v", space.Name, err)
+		}
+		if m.spaces == nil {
+			m.spaces = make(map[string]spaceInfo)
+		}
+		m.spaces[guid] = spaceInfo{Name: space.Name, OrgName: org.Name, OrgGUID: org.Guid}
+	}
+	if len(apps) == 0 {
+		fmt.Printf("Space %s has no apps, nothing to scrape\n", guid)
+	}
+	return apps, nil
+}
+
+// scrape fetches the stats of all monitored apps and updates the gauges
+func (m *monitorState) scrape() {
+	if !m.loggedIn {
+		return
+	}
+	if len(m.apps) == 0 {
+		if err := m.discover(); err != nil {
+			fmt.Printf("Error fetching apps: %v\n", err)
+			scrapeErrorsCounter.WithLabelValues("apps").Inc()
+			return
+		}
+		if len(m.apps) == 0 {
+			appsGauge.Set(0)
+			markScraped(time.Now())
+			return
+		}
+	}
+	start := time.Now()
+	var before runtime.MemStats
+	if *enableDebug {
+		runtime.ReadMemStats(&before)
+	}
+	skipped, fetched, failed := 0, 0, 0
+	var samples []cellSample
+	m.scrapes++
+	for _, batch := range batches(prioritize(m.apps, m.activeConfig.PriorityApps), *batchSize) {
+		var todo []cfclient.App
+		for _, app := range batch {
+			if isSelf(*selfExclusion, app, m.activeConfig) {
+				continue
+			}
+			if _, ok := intervalFor(app, appIntervals); ok {
+				continue // Scraped on its own schedule
+			}
+			if m.skipIdle(app, *idleAfter, *idleProbeEvery) {
+				continue
+			}
+			if *scrapeDeadline > 0 && time.Since(start) > *scrapeDeadline && !isPriority(app, m.activeConfig.PriorityApps) {
+				skipped++
+				continue
+			}
+			todo = append(todo, app)
+		}
+		// Gauges are updated here, by the monitor goroutine only
+		for i, r := range fetchAll(m.client, todo, *concurrency, m.timeout.current) {
+			fetched++
+			if r.err != nil && !cfclient.IsAppNotFoundError(r.err) && !cfclient.IsAppStoppedStatsError(r.err) {
+				failed++
+			}
+			samples = append(samples, m.record(todo[i], r.stats, r.truncated, r.err)...)
+			m.observeFetch(todo[i], r.took)
+			if *logRate && r.err == nil {
+				m.recordLogRates(todo[i], r.logRates, r.logErr)
+			}
+		}
+	}
+	if *cpuSteal {
+		updateCPUSteal(samples)
+	}
+	if *orgCounts {
+		m.updateOrgCounts()
+	}
+	fmt.Printf("Fetching stats of %d apps took %s\n", len(m.apps), time.Since(start))
+	if skipped > 0 {
+		fmt.Printf("Scrape deadline of %s exceeded, skipped %d apps\n", *scrapeDeadline, skipped)
+	}
+	if fetched > 0 {
+		// Only a scrape in which every app failed slows down the next
+		effective := m.scrapeBackoff.scraped(failed < fetched, intervals[groupStats], *scrapeBackoffMax)
+		scrapeIntervalGauge.Set(effective.Seconds())
+	}
+	appsGauge.Set(float64(len(m.apps)))
+	updateClockSkew()
+	now := time.Now()
+	scrapeDurationGauge.Set(now.Sub(start).Seconds())
+	m.timeout.adjust(now.Sub(start), intervals[groupStats])
+	lastScrapeGauge.Set(float64(now.UnixNano()) / 1e9)
+	markScraped(now)
+	for _, f := range afterScrape {
+		f()
+	}
+	if *enableDebug {
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+		scrapeAllocGauge.Set(float64(after.TotalAlloc - before.TotalAlloc))
+		heapInuseGauge.Set(float64(after.HeapInuse))
+	}
+}
+
+// record updates the gauges of app from the outcome of fetching its
+// stats, returning the samples for the CPU steal approximation
+func (m *monitorState) record(app cfclient.App, stats map[string]cfclient.AppStats, truncated bool, err error) []cellSample {
+	if cfclient.IsAppNotFoundError(err) {
+		// Deleted since the last discovery, not a scrape error
+		fmt.Printf("App %s was deleted, deleting its series\n", app.Name)
+		m.removeApp(app)
+		return nil
+	}
+	// Relabel first, moving an app deletes the series under its old labels
+	series := m.relabel(app)
+	if cfclient.IsAppStoppedStatsError(err) {
+		// A stopped app has no instances, report it as such
+		stats, err = map[string]cfclient.AppStats{}, nil
+	}
+	if err != nil {
+		m.failures[app.Guid]++
+		failuresGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(float64(m.failures[app.Guid]))
+		fmt.Printf("Error fetching stats of %s: %v\n", app.Name, err)
+		scrapeErrorsCounter.WithLabelValues("stats").Inc()
+		return nil
+	}
+	m.failures[app.Guid] = 0
+	failuresGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(0)
+	m.trackIdle(app, stats)
+	if truncated {
+		truncatedGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(1)
+	} else {
+		truncatedGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(0)
+	}
+	stats, rawKeys := normalizeInstances(stats)
+	for i, key := range rawKeys {
+		if prev, ok := series.Keys[i]; ok && prev != key {
+			instanceKeyGauge.DeleteLabelValues(series.Org, series.Space, series.App, series.GUID, i, prev)
+		}
+		instanceKeyGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i, key).Set(1)
+		series.Keys[i] = key
+	}
+	var samples []cellSample
+	cpus := make([]float64, 0, len(stats))
+	usages := make(map[string]instanceUsage, len(stats))
+	for i, s := range stats {
+		cpus = append(cpus, s.Stats.Usage.CPU*100)
+		usages[i] = instanceUsage{
+			CPU:   s.Stats.Usage.CPU * 100,
+			Mem:   float64(s.Stats.Usage.Mem),
+			Disk:  float64(s.Stats.Usage.Disk),
+			State: s.State,
+		}
+		memQuotaGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i).Set(float64(s.Stats.MemQuota))
+		diskQuotaGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i).Set(float64(s.Stats.DiskQuota))
+		if prev, ok := series.Instances[i]; ok && prev != s.State {
+			instanceStateGauge.DeleteLabelValues(series.Org, series.Space, series.App, series.GUID, i, prev)
+		}
+		instanceStateGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID, i, s.State).Set(1)
+		series.Instances[i] = s.State
+		if *cpuSteal {
+			samples = append(samples, cellSample{series.appLabels, series.GUID, i, s.Stats.Host, s.Stats.Usage.CPU})
+		}
+	}
+	for i := range series.Instances {
+		if _, ok := stats[i]; !ok { // Scaled down
+			series.deleteInstance(i)
+		}
+	}
+	usage.set(series.appLabels, series.GUID, usages, time.Now())
+	running, crashed := countStates(stats)
+	runningInstancesGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(float64(running))
+	crashedInstancesGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(float64(crashed))
+	m.trackStarts(app, series, stats)
+	if *recoveryTime {
+		m.trackRecovery(app, series, stats, time.Now())
+	}
+	if *chargeback != "" {
+		m.charge(*chargeback, app, series, stats, time.Now())
+	}
+	if *exportAvailability && !truncated {
+		if ratio, ok := availability(app.Instances, stats); ok && app.State != "STOPPED" {
+			availabilityGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(ratio)
+		} else {
+			availabilityGauge.DeleteLabelValues(series.Org, series.Space, series.App, series.GUID)
+		}
+	}
+	if *appCPUP95 {
+		if len(cpus) > 0 {
+			appCPUP95Gauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(percentile(cpus, 95))
+		} else {
+			appCPUP95Gauge.DeleteLabelValues(series.Org, series.Space, series.App, series.GUID)
+		}
+	}
+	return samples
+}
+
+// resolveSpace looks up the space GUID and reports whether it resolved
+func resolveSpace(client *cfclient.Client, guid string) (cfclient.Space, bool) {
+	space, err := client.GetSpaceByGuid(guid)
+	if err != nil {
+		fmt.Printf("Unable to resolve space GUID %s: %v\n", guid, err)
+		spaceResolvedGauge.WithLabelValues(guid).Set(0)
+		return space, false
+	}
+	spaceResolvedGauge.WithLabelValues(guid).Set(1)
+	return space, true
+}
+
+// updateLimits exports the configured per instance limits of apps
+func (m *monitorState) updateLimits() {
+	for _, app := range m.apps {
+		l := m.labelsFor(app)
+		memLimitGauge.WithLabelValues(l.Org, l.Space, l.App, app.Guid).Set(float64(app.Memory) * 1024 * 1024)
+		diskLimitGauge.WithLabelValues(l.Org, l.Space, l.App, app.Guid).Set(float64(app.DiskQuota) * 1024 * 1024)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// spaceInfo holds the names used to label the metrics of apps in a space
+type spaceInfo struct {
+	Name    string
+	OrgName string
+	OrgGUID string
+}
+
+// discoverAll lists the apps in all orgs allowed by the include and
+// exclude lists of c, together with the names of their spaces
+func discoverAll(client *cfclient.Client, c config) ([]cfclient.App, map[string]spaceInfo, error) {
+	orgs, err := client.ListOrgs()
+	if err != nil {
+		return nil, nil, err
+	}
+	allowed := make(map[string]string)
+	var excluded []string
+	for _, org := range orgs {
+		if orgAllowed(org, c.IncludeOrgs, c.ExcludeOrgs, c.SystemOrgs) {
+			allowed[org.Guid] = org.Name
+		} else {
+			excluded = append(excluded, org.Name)
+		}
+	}
+	if len(excluded) > 0 {
+		fmt.Printf("Excluded orgs: %s\n", strings.Join(excluded, ","))
+	}
+	orgsGauge.Set(float64(len(allowed)))
+
+	spaceList, err := client.ListSpaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	spaces := make(map[string]spaceInfo)
+	for _, space := range spaceList {
+		if orgName, ok := allowed[space.OrganizationGuid]; ok {
+			spaces[space.Guid] = spaceInfo{Name: space.Name, OrgName: orgName, OrgGUID: space.OrganizationGuid}
+		}
+	}
+
+	filtered := 0
+	apps, err := listApps(client, url.Values{}, *batchSize, func(app cfclient.App) bool {
+		_, ok := spaces[app.SpaceGuid]
+		if !ok {
+			filtered++
+		}
+		return ok
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	filteredAppsGauge.Set(float64(filtered))
+	return apps, spaces, nil
+}
+
+// orgAllowed reports whether org passes the include and exclude lists.
+// An empty include list allows all orgs except the system orgs. System
+// orgs are only monitored when explicitly included
+func orgAllowed(org cfclient.Org, include, exclude, system []string) bool {
+	if matchesOrg(org, exclude) {
+		return false
+	}
+	if matchesOrg(org, include) {
+		return true
+	}
+	return len(include) == 0 && !matchesOrg(org, system)
+}
+
+func matchesOrg(org cfclient.Org, list []string) bool {
+	for _, s := range list {
+		if s == org.Name || s == org.Guid {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitTarget splits an org/space path into its org and space names
+func splitTarget(path string) (org, space string, err error) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("target %q is not of the form org/space", path)
+	}
+	return parts[0], parts[1], nil
+}
+
+// resolveTarget returns the GUID of the space at the org/space path
+func resolveTarget(client *cfclient.Client, path string) (string, error) {
+	orgName, spaceName, err := splitTarget(path)
+	if err != nil {
+		return "", err
+	}
+	org, err := client.GetOrgByName(orgName)
+	if err != nil {
+		return "", fmt.Errorf("org %s: %v", orgName, err)
+	}
+	space, err := client.GetSpaceByName(spaceName, org.Guid)
+	if err != nil {
+		return "", fmt.Errorf("space %s in org %s: %v", spaceName, orgName, err)
+	}
+	return space.Guid, nil
+}
+
+// updateOrgInfo exports the quota definition name of every monitored org
+func (m *monitorState) updateOrgInfo() {
+	orgs := make(map[string]string)
+	for _, info := range m.spaces {
+		if info.OrgGUID != "" {
+			orgs[info.OrgGUID] = info.OrgName
+		}
+	}
+	orgInfoGauge.Reset()
+	for guid, name := range orgs {
+		org, err := m.client.GetOrgByGuid(guid)
+		if err != nil {
+			fmt.Printf("Error fetching org %s: %v\n", name, err)
+			continue
+		}
+		quota, err := org.Quota()
+		if err != nil {
+			fmt.Printf("Error fetching quota of org %s: %v\n", name, err)
+			continue
+		}
+		quotaName := ""
+		if quota != nil {
+			quotaName = quota.Name
+		}
+		orgInfoGauge.WithLabelValues(sanitize(*sanitizeLabels, name), quotaName).Set(1)
+	}
+}
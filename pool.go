@@ -0,0 +1,70 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// fetchResult is the outcome of fetching the stats of one app
+type fetchResult struct {
+	stats     map[string]cfclient.AppStats
+	truncated bool
+	err       error
+	took      time.Duration
+	logRates  map[string]instanceLogRate
+	logErr    error
+}
+
+// pendingFetches counts the stats fetches waiting for a worker
+var pendingFetches int64
+
+// fetchAll fetches the stats of apps using at most concurrency workers.
+// A failing app does not hold up the others. The results are returned
+// in the order of apps
+func fetchAll(client *cfclient.Client, apps []cfclient.App, concurrency int, timeout time.Duration) []fetchResult {
+	results := make([]fetchResult, len(apps))
+	if concurrency > len(apps) {
+		concurrency = len(apps)
+	}
+	atomic.AddInt64(&pendingFetches, int64(len(apps)))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				atomic.AddInt64(&pendingFetches, -1)
+				start := time.Now()
+				r := &results[i]
+				r.stats, r.truncated, r.err = fetchAppStats(client, apps[i].Guid, *maxInstances, timeout)
+				r.took = time.Since(start)
+				if *logRate && r.err == nil {
+					r.logRates, r.logErr = fetchLogRates(client, apps[i].Guid, timeout)
+				}
+			}
+		}()
+	}
+	for i := range apps {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// observeFetch records how long fetching the stats of app took
+func (m *monitorState) observeFetch(app cfclient.App, took time.Duration) {
+	if !*appScrapeTiming {
+		return
+	}
+	l := m.labelsFor(app)
+	appScrapeHistogram.WithLabelValues(l.Org, l.Space, l.App, app.Guid).Observe(took.Seconds())
+}
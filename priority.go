@@ -0,0 +1,36 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// prioritize returns apps with the apps matching priority by name or
+// GUID moved to the front, preserving the order otherwise
+func prioritize(apps []cfclient.App, priority []string) []cfclient.App {
+	if len(priority) == 0 {
+		return apps
+	}
+	ordered := make([]cfclient.App, 0, len(apps))
+	var rest []cfclient.App
+	for _, app := range apps {
+		if isPriority(app, priority) {
+			ordered = append(ordered, app)
+		} else {
+			rest = append(rest, app)
+		}
+	}
+	return append(ordered, rest...)
+}
+
+func isPriority(app cfclient.App, priority []string) bool {
+	for _, p := range priority {
+		if p == app.Name || p == app.Guid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Compositions of the instance label of pushed samples
+const (
+	instanceGUID      = "guid"
+	instanceGUIDIndex = "guid-index"
+	instanceApp       = "app"
+	instanceAppIndex  = "app-index"
+)
+
+// pushGateway pushes all metrics to a Prometheus Pushgateway
+type pushGateway struct {
+	url      string
+	job      string
+	gatherer prometheus.Gatherer
+}
+
+func newPushGateway(pushURL, job string, gatherer prometheus.Gatherer) *pushGateway {
+	if !strings.Contains(pushURL, "://") {
+		pushURL = "http://" + pushURL
+	}
+	return &pushGateway{
+		url:      strings.TrimRight(pushURL, "/"),
+		job:      job,
+		gatherer: gatherer,
+	}
+}
+
+// push replaces the metrics of our job on the Pushgateway
+func (p *pushGateway) push() {
+	if err := push.FromGatherer(p.job, nil, p.url, p.gatherer); err != nil {
+		fmt.Printf("Error pushing to Pushgateway: %v\n", err)
+	}
+}
+
+// delete removes the metrics of our job from the Pushgateway
+// so no stale series linger after cfprom stops
+func (p *pushGateway) delete() {
+	req, err := http.NewRequest(http.MethodDelete, p.url+"/metrics/job/"+url.QueryEscape(p.job), nil)
+	if err != nil {
+		fmt.Printf("Error deleting from Pushgateway: %v\n", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Error deleting from Pushgateway: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		fmt.Printf("Unexpected status deleting from Pushgateway: %s\n", resp.Status)
+	}
+}
+
+func validPushInstance(mode string) error {
+	switch mode {
+	case "", instanceGUID, instanceGUIDIndex, instanceApp, instanceAppIndex:
+		return nil
+	}
+	return fmt.Errorf("unknown pushgateway instance composition %q", mode)
+}
+
+// instanceLabelGatherer sets the instance label of every sample of an app
+// from its app_guid or app label, optionally followed by a slash and the
+// instance_index label. Samples without these labels are left alone
+type instanceLabelGatherer struct {
+	prometheus.Gatherer
+	mode string
+}
+
+func (g instanceLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			value := instanceValue(g.mode, labelMap(m))
+			if value == "" {
+				continue
+			}
+			labels := m.Label[:0]
+			for _, l := range m.Label {
+				if l.GetName() != "instance" {
+					labels = append(labels, l)
+				}
+			}
+			name := "instance"
+			m.Label = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return mfs, err
+}
+
+func instanceValue(mode string, labels map[string]string) string {
+	var value string
+	switch mode {
+	case instanceGUID, instanceGUIDIndex:
+		value = labels["app_guid"]
+	case instanceApp, instanceAppIndex:
+		value = labels["app"]
+	}
+	if value == "" {
+		return ""
+	}
+	if index, ok := labels["instance_index"]; ok && (mode == instanceGUIDIndex || mode == instanceAppIndex) {
+		value += "/" + index
+	}
+	return value
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// pendingConfigs counts the senders blocked handing a configuration
+	// to the monitor
+	pendingConfigs int64
+
+	// pendingScrapes counts the scrape requests since the monitor last
+	// picked one up. Requests made while one is pending are coalesced
+	pendingScrapes int64
+)
+
+// registerQueueGauges exports the depths of the internal queues,
+// sampled whenever the metrics are gathered
+func registerQueueGauges() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cfprom_reconfig_queue_depth",
+			Help: "Number of configurations waiting to be picked up by the monitor",
+		},
+		func() float64 { return float64(atomic.LoadInt64(&pendingConfigs)) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cfprom_work_queue_depth",
+			Help: "Number of scrape requests waiting to be served by the monitor",
+		},
+		func() float64 { return float64(atomic.LoadInt64(&pendingScrapes)) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cfprom_fetch_queue_depth",
+			Help: "Number of app stats fetches waiting for a worker",
+		},
+		func() float64 { return float64(atomic.LoadInt64(&pendingFetches)) }))
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	rateLimitRetries  = 3
+	defaultRetryAfter = 5 * time.Second
+	maxRetryAfter     = time.Minute
+)
+
+var rateLimitedCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "cf_ratelimited_total",
+		Help: "Number of CF API responses with status 429 Too Many Requests",
+	})
+
+// rateLimitTransport retries requests rejected with 429 Too Many Requests
+// after the delay requested by the CF API
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+		rateLimitedCounter.Inc()
+		if attempt == rateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		scrapeRetriesCounter.WithLabelValues("rate_limit").Inc()
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		resp.Body.Close()
+		fmt.Printf("CF API rate limited %s %s, retrying in %s\n", req.Method, req.URL.Path, wait)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an
+// HTTP date, capping the delay at maxRetryAfter
+func retryAfter(header string, now time.Time) time.Duration {
+	wait := defaultRetryAfter
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		wait = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(header); err == nil {
+		wait = at.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var crashRateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "instance_crashes_rate",
+		Help: "Instance crashes per second between the two most recent crash polls",
+	},
+	[]string{"org", "space", "app", "app_guid"})
+
+type rateSample struct {
+	value float64
+	at    time.Time
+}
+
+// rateTracker derives per second rates from cumulative counters
+type rateTracker map[string]rateSample
+
+// observe records value for key and returns its rate since the previous
+// observation. A counter which decreased is treated as a fresh start
+// and, like the first observation, yields no rate
+func (r rateTracker) observe(key string, value float64, at time.Time) (float64, bool) {
+	prev, ok := r[key]
+	r[key] = rateSample{value: value, at: at}
+	if !ok || value < prev.value {
+		return 0, false
+	}
+	elapsed := at.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return (value - prev.value) / elapsed, true
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var recoveryGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "app_recovery_seconds",
+		Help: "Time the last restart of all instances of an app took until all desired instances were running",
+	},
+	[]string{"org", "space", "app", "app_guid"})
+
+// recoveryState tracks the restarts of the instances of an app
+type recoveryState struct {
+	uptimes     map[string]int // Instance index to uptime in seconds
+	restartedAt time.Time      // Zero unless recovering
+}
+
+// trackRecovery detects a restart of all instances of app, i.e. none of
+// the instances of the previous scrape kept running, and exports how long
+// it took until the desired number of instances was running again
+func (m *monitorState) trackRecovery(app cfclient.App, series appSeries, stats map[string]cfclient.AppStats, now time.Time) {
+	st, ok := m.recovery[app.Guid]
+	if !ok {
+		st = &recoveryState{}
+		m.recovery[app.Guid] = st
+	}
+	uptimes := make(map[string]int, len(stats))
+	restarted := len(st.uptimes) > 0 && len(stats) > 0
+	running, maxUptime := 0, 0
+	for i, s := range stats {
+		uptime := s.Stats.Uptime
+		if s.State != "RUNNING" {
+			uptime = 0
+		} else {
+			running++
+		}
+		if prev, ok := st.uptimes[i]; ok && uptime >= prev && uptime > 0 {
+			restarted = false // Kept running
+		}
+		if uptime > maxUptime {
+			maxUptime = uptime
+		}
+		uptimes[i] = uptime
+	}
+	st.uptimes = uptimes
+	if restarted && st.restartedAt.IsZero() {
+		// The first instance came back maxUptime ago
+		st.restartedAt = now.Add(-time.Duration(maxUptime) * time.Second)
+	}
+	if !st.restartedAt.IsZero() && running >= app.Instances {
+		recoveryGauge.WithLabelValues(series.Org, series.Space, series.App, series.GUID).Set(now.Sub(st.restartedAt).Seconds())
+		st.restartedAt = time.Time{}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var appInfoGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "app_info",
+		Help: "Runtime of an app derived from its buildpack, always 1",
+	},
+	[]string{"org", "space", "app", "app_guid", "runtime"})
+
+// buildpackRuntimes maps buildpack name fragments to runtimes, in match order
+var buildpackRuntimes = []struct {
+	fragment, runtime string
+}{
+	{"java", "java"},
+	{"node", "nodejs"},
+	{"python", "python"},
+	{"go_buildpack", "go"},
+	{"go-buildpack", "go"},
+	{"ruby", "ruby"},
+	{"php", "php"},
+	{"dotnet", "dotnet"},
+	{"staticfile", "static"},
+	{"nginx", "nginx"},
+	{"binary", "binary"},
+}
+
+// runtimeOf derives a coarse runtime from the buildpack of app
+func runtimeOf(app cfclient.App) string {
+	if app.DockerImage != "" {
+		return "docker"
+	}
+	bp := strings.ToLower(app.Buildpack)
+	if bp == "" {
+		bp = strings.ToLower(app.DetectedBuildpack)
+	}
+	if bp == "go" {
+		return "go"
+	}
+	for _, r := range buildpackRuntimes {
+		if strings.Contains(bp, r.fragment) {
+			return r.runtime
+		}
+	}
+	return "unknown"
+}
+
+// updateAppInfo exports the runtime of every monitored app
+func (m *monitorState) updateAppInfo() {
+	for _, app := range m.apps {
+		s := m.relabel(app)
+		runtime := runtimeOf(app)
+		if s.Runtime == runtime {
+			continue
+		}
+		if s.Runtime != "" {
+			appInfoGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, s.Runtime)
+		}
+		appInfoGauge.WithLabelValues(s.Org, s.Space, s.App, s.GUID, runtime).Set(1)
+		s.Runtime = runtime
+		m.series[app.Guid] = s
+	}
+}
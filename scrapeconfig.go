@@ -0,0 +1,45 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// scrapeConfigHandler returns a Prometheus scrape_configs snippet for
+// scraping this cfprom instance as it was reached by the request.
+// The password is never included
+func scrapeConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "scrape_configs:\n")
+		fmt.Fprintf(&b, "  - job_name: cfprom\n")
+		fmt.Fprintf(&b, "    scheme: %s\n", scheme)
+		fmt.Fprintf(&b, "    metrics_path: /metrics\n")
+		fmt.Fprintf(&b, "    scrape_interval: %s\n", intervals[groupStats])
+		if len(metricsPasswords.get()) > 0 {
+			fmt.Fprintf(&b, "    basic_auth:\n")
+			fmt.Fprintf(&b, "      username: %s\n", metricsUsername())
+			fmt.Fprintf(&b, "      password: <PASSWORD>\n")
+		}
+		fmt.Fprintf(&b, "    static_configs:\n")
+		fmt.Fprintf(&b, "      - targets: ['%s']\n", req.Host)
+		if *foundationName == "" {
+			fmt.Fprintf(&b, "    # When scraping several foundations, start cfprom with -foundation-name\n")
+			fmt.Fprintf(&b, "    # to tell them apart\n")
+		}
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
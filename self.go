@@ -0,0 +1,68 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// prefixGatherer prepends a prefix to the name of every metric family
+// gathered from the wrapped Gatherer
+type prefixGatherer struct {
+	prometheus.Gatherer
+	prefix string
+}
+
+func (g prefixGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		name := g.prefix + mf.GetName()
+		mf.Name = &name
+	}
+	return mfs, err
+}
+
+// namespacedSelfMetrics moves the Go and process collectors of the default
+// registry to a registry whose metric names are prefixed with namespace
+func namespacedSelfMetrics(namespace string) prometheus.Gatherer {
+	prometheus.Unregister(prometheus.NewGoCollector())
+	prometheus.Unregister(prometheus.NewProcessCollector(os.Getpid(), ""))
+	self := prometheus.NewRegistry()
+	self.MustRegister(prometheus.NewGoCollector())
+	self.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
+	return prefixGatherer{self, namespace + "_"}
+}
+
+// Self exclusion modes
+const (
+	selfByGUID = "guid"
+	selfByName = "name"
+	selfNone   = "none"
+)
+
+func validSelfExclusion(mode string) error {
+	switch mode {
+	case selfByGUID, selfByName, selfNone:
+		return nil
+	}
+	return fmt.Errorf("unknown self exclusion mode %q", mode)
+}
+
+// isSelf reports whether app is cfprom itself according to mode
+func isSelf(mode string, app cfclient.App, c config) bool {
+	switch mode {
+	case selfByGUID:
+		return c.AppID != "" && app.Guid == c.AppID
+	case selfByName:
+		return c.AppName != "" && app.Name == c.AppName
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// appSeries records the labels last used to report an app
+type appSeries struct {
+	appLabels
+	Raw       appLabels
+	GUID      string
+	Instances map[string]string // Instance index to last state
+	Keys      map[string]string // Instance index to non-integer stats key
+	Runtime   string
+}
+
+// delete removes all per app series reported under these labels
+func (s appSeries) delete() {
+	for i := range s.Instances {
+		s.deleteInstance(i)
+	}
+	usage.delete(s.GUID)
+	memLimitGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	diskLimitGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	crashRateGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	appCPUP95Gauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	availabilityGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	recoveryGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	runningInstancesGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	crashedInstancesGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	instanceStartsCounter.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	allocatedGBHoursCounter.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	usedGBHoursCounter.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	if s.Runtime != "" {
+		appInfoGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, s.Runtime)
+	}
+	labelInfoGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, s.Raw.Org, s.Raw.Space, s.Raw.App)
+	for _, reason := range crashReasonValues {
+		crashCounter.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, reason)
+	}
+	failuresGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	truncatedGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+	appScrapeHistogram.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID)
+}
+
+// deleteInstance removes the series of instance i
+func (s appSeries) deleteInstance(i string) {
+	memQuotaGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i)
+	diskQuotaGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i)
+	instanceStateGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i, s.Instances[i])
+	s.deleteLogRates(i)
+	delete(s.Instances, i)
+	if key, ok := s.Keys[i]; ok {
+		instanceKeyGauge.DeleteLabelValues(s.Org, s.Space, s.App, s.GUID, i, key)
+		delete(s.Keys, i)
+	}
+}
+
+// spaceInfoFor returns the names of the space of app, resolving
+// them when the app moved to a space that is not known yet
+func (m *monitorState) spaceInfoFor(app cfclient.App) spaceInfo {
+	if info, ok := m.spaces[app.SpaceGuid]; ok {
+		return info
+	}
+	info, err := fetchSpaceInfo(m.client, app.SpaceGuid)
+	if err != nil {
+		fmt.Printf("Error resolving space %s of %s: %v\n", app.SpaceGuid, app.Name, err)
+		return spaceInfo{}
+	}
+	if m.spaces == nil {
+		m.spaces = make(map[string]spaceInfo)
+	}
+	m.spaces[app.SpaceGuid] = info
+	return info
+}
+
+// refreshSpaceNames resolves the names of the cached spaces again so
+// renamed orgs and spaces are picked up. A space which fails to resolve
+// keeps its previous names
+func (m *monitorState) refreshSpaceNames() {
+	for guid, prev := range m.spaces {
+		info, err := fetchSpaceInfo(m.client, guid)
+		if err != nil {
+			fmt.Printf("Error refreshing names of space %s, keeping %s/%s: %v\n", guid, prev.OrgName, prev.Name, err)
+			continue
+		}
+		if info != prev {
+			fmt.Printf("Space %s renamed from %s/%s to %s/%s\n", guid, prev.OrgName, prev.Name, info.OrgName, info.Name)
+		}
+		m.spaces[guid] = info
+	}
+}
+
+// fetchSpaceInfo looks up the names of the space guid and its org
+func fetchSpaceInfo(client *cfclient.Client, guid string) (spaceInfo, error) {
+	space, err := client.GetSpaceByGuid(guid)
+	if err != nil {
+		return spaceInfo{}, err
+	}
+	org, err := space.Org()
+	if err != nil {
+		return spaceInfo{}, fmt.Errorf("resolving org of space %s: %v", space.Name, err)
+	}
+	return spaceInfo{Name: space.Name, OrgName: org.Name, OrgGUID: org.Guid}, nil
+}
+
+// prune deletes the series and state of apps which are no longer monitored
+func (m *monitorState) prune() {
+	current := make(map[string]bool, len(m.apps))
+	for _, app := range m.apps {
+		current[app.Guid] = true
+	}
+	for guid, s := range m.series {
+		if current[guid] {
+			continue
+		}
+		fmt.Printf("App %s is no longer monitored, deleting its series\n", s.Raw.App)
+		m.forget(guid)
+	}
+}
+
+// forget deletes the series and the state kept for the app guid
+func (m *monitorState) forget(guid string) {
+	if s, ok := m.series[guid]; ok {
+		s.delete()
+	}
+	delete(m.series, guid)
+	delete(m.failures, guid)
+	delete(m.idle, guid)
+	delete(m.running, guid)
+	delete(m.charged, guid)
+	delete(m.recovery, guid)
+	delete(m.crashes, guid)
+	delete(m.rates, "crashes/"+guid)
+}
+
+// removeApp stops monitoring app until it is discovered again. The apps
+// are copied as scrape may be iterating over them
+func (m *monitorState) removeApp(app cfclient.App) {
+	apps := make([]cfclient.App, 0, len(m.apps))
+	for _, a := range m.apps {
+		if a.Guid != app.Guid {
+			apps = append(apps, a)
+		}
+	}
+	m.apps = apps
+	m.forget(app.Guid)
+	setDiscovered(m.spaces, len(m.apps))
+}
+
+// relabel deletes the series of app when its org, space or name changed
+// since it was last reported and returns the series record to report into.
+// The returned labels are sanitized
+func (m *monitorState) relabel(app cfclient.App) appSeries {
+	raw := m.rawLabelsFor(app)
+	prev, ok := m.series[app.Guid]
+	if ok && prev.Raw == raw {
+		return prev
+	}
+	if ok {
+		fmt.Printf("App %s moved to %s/%s, relabeling\n", app.Name, raw.Org, raw.Space)
+		prev.delete()
+	}
+	s := appSeries{
+		appLabels: raw.sanitized(*sanitizeLabels),
+		Raw:       raw,
+		GUID:      app.Guid,
+		Instances: make(map[string]string),
+		Keys:      make(map[string]string),
+	}
+	if *sanitizeLabels != sanitizeNone {
+		labelInfoGauge.WithLabelValues(s.Org, s.Space, s.App, s.GUID, raw.Org, raw.Space, raw.App).Set(1)
+	}
+	m.series[app.Guid] = s
+	return s
+}
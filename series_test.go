@@ -0,0 +1,210 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func testStats(states ...string) map[string]cfclient.AppStats {
+	stats := make(map[string]cfclient.AppStats, len(states))
+	for i, state := range states {
+		var s cfclient.AppStats
+		s.State = state
+		s.Stats.MemQuota = 1 << 30
+		stats[strconv.Itoa(i)] = s
+	}
+	return stats
+}
+
+func TestRelabelMovedApp(t *testing.T) {
+	spaces := map[string]spaceInfo{
+		"space-dev":  {Name: "dev", OrgName: "acme"},
+		"space-prod": {Name: "prod", OrgName: "acme"},
+		"space-ops":  {Name: "dev", OrgName: "ops"},
+	}
+	tests := []struct {
+		name  string
+		to    cfclient.App
+		moved bool
+	}{
+		{"unchanged", cfclient.App{Guid: "guid-1", Name: "web", SpaceGuid: "space-dev"}, false},
+		{"other space", cfclient.App{Guid: "guid-1", Name: "web", SpaceGuid: "space-prod"}, true},
+		{"other org", cfclient.App{Guid: "guid-1", Name: "web", SpaceGuid: "space-ops"}, true},
+		{"renamed", cfclient.App{Guid: "guid-1", Name: "api", SpaceGuid: "space-dev"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMonitorState()
+			m.spaces = spaces
+			from := cfclient.App{Guid: "guid-1", Name: "web", SpaceGuid: "space-dev"}
+			m.record(from, testStats("RUNNING"), false, nil)
+			old := m.series[from.Guid]
+			defer m.forget(from.Guid)
+
+			m.record(tt.to, testStats("RUNNING"), false, nil)
+			s := m.series[tt.to.Guid]
+			if got := s.Raw; got != m.rawLabelsFor(tt.to) {
+				t.Fatalf("series labels = %+v, want %+v", got, m.rawLabelsFor(tt.to))
+			}
+			for _, labels := range []appLabels{old.appLabels, s.appLabels} {
+				current := labels == s.appLabels
+				want := current || !tt.moved
+				instance := map[string]string{"org": labels.Org, "space": labels.Space, "app": labels.App, "app_guid": "guid-1", "instance_index": "0"}
+				if got := hasSeries(memQuotaGauge, instance); got != want {
+					t.Errorf("mem_quota for %+v present = %v, want %v", labels, got, want)
+				}
+				app := map[string]string{"org": labels.Org, "space": labels.Space, "app": labels.App}
+				if got := hasSeries(runningInstancesGauge, app); got != want {
+					t.Errorf("app_running_instances for %+v present = %v, want %v", labels, got, want)
+				}
+				app["app_guid"] = "guid-1"
+				if got := hasSeries(failuresGauge, app); got != want {
+					t.Errorf("cfprom_consecutive_scrape_failures for %+v present = %v, want %v", labels, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordDeletedApp(t *testing.T) {
+	m := newMonitorState()
+	m.spaces = map[string]spaceInfo{"space-dev": {Name: "dev", OrgName: "acme"}}
+	m.apps = []cfclient.App{
+		{Guid: "guid-a", Name: "a", SpaceGuid: "space-dev"},
+		{Guid: "guid-b", Name: "b", SpaceGuid: "space-dev"},
+		{Guid: "guid-c", Name: "c", SpaceGuid: "space-dev"},
+	}
+	defer func() {
+		for _, app := range m.apps {
+			m.forget(app.Guid)
+		}
+	}()
+	for _, app := range m.apps {
+		m.record(app, testStats("RUNNING"), false, nil)
+	}
+
+	errors := counterValue(scrapeErrorsCounter.WithLabelValues("stats"))
+
+	// As in scrape, b is reported deleted while iterating over the apps
+	batch := m.apps
+	notFound := cfclient.CloudFoundryError{Code: 100004, ErrorCode: "CF-AppNotFound"}
+	var recorded []string
+	for _, app := range batch {
+		var err error
+		if app.Guid == "guid-b" {
+			err = notFound
+		}
+		m.record(app, testStats("RUNNING"), false, err)
+		recorded = append(recorded, app.Guid)
+	}
+
+	if want := []string{"guid-a", "guid-b", "guid-c"}; !reflect.DeepEqual(recorded, want) {
+		t.Errorf("iterated over %v, want %v", recorded, want)
+	}
+	var guids []string
+	for _, app := range m.apps {
+		guids = append(guids, app.Guid)
+	}
+	if want := []string{"guid-a", "guid-c"}; !reflect.DeepEqual(guids, want) {
+		t.Errorf("apps = %v, want %v", guids, want)
+	}
+	if _, ok := m.series["guid-b"]; ok {
+		t.Error("series of the deleted app kept")
+	}
+	for _, app := range []string{"a", "b", "c"} {
+		want := app != "b"
+		labels := map[string]string{"org": "acme", "space": "dev", "app": app}
+		if got := hasSeries(memQuotaGauge, labels); got != want {
+			t.Errorf("mem_quota of %s present = %v, want %v", app, got, want)
+		}
+		if got := hasSeries(runningInstancesGauge, labels); got != want {
+			t.Errorf("app_running_instances of %s present = %v, want %v", app, got, want)
+		}
+		if got := hasSeries(failuresGauge, map[string]string{"app": app, "app_guid": "guid-" + app}); got != want {
+			t.Errorf("cfprom_consecutive_scrape_failures of %s present = %v, want %v", app, got, want)
+		}
+		if _, _, got := usage.instance("guid-"+app, "0"); got != want {
+			t.Errorf("cached usage of %s present = %v, want %v", app, got, want)
+		}
+	}
+	if got := counterValue(scrapeErrorsCounter.WithLabelValues("stats")); got != errors {
+		t.Errorf("a deleted app counted as %v scrape errors", got-errors)
+	}
+}
+
+func TestFailuresOfSameNamedApps(t *testing.T) {
+	m := newMonitorState()
+	m.spaces = map[string]spaceInfo{
+		"space-dev":  {Name: "dev", OrgName: "acme"},
+		"space-prod": {Name: "prod", OrgName: "acme"},
+	}
+	dev := cfclient.App{Guid: "guid-dev", Name: "web", SpaceGuid: "space-dev"}
+	prod := cfclient.App{Guid: "guid-prod", Name: "web", SpaceGuid: "space-prod"}
+	defer m.forget(dev.Guid)
+	defer m.forget(prod.Guid)
+
+	m.record(dev, nil, false, fmt.Errorf("timeout"))
+	m.record(dev, nil, false, fmt.Errorf("timeout"))
+	m.record(prod, testStats("RUNNING"), false, nil)
+	if got := gaugeValue(failuresGauge.WithLabelValues("acme", "dev", "web", "guid-dev")); got != 2 {
+		t.Errorf("failures of web in dev = %v, want 2", got)
+	}
+
+	m.forget(prod.Guid)
+	if !hasSeries(failuresGauge, map[string]string{"space": "dev", "app": "web"}) {
+		t.Error("forgetting web in prod deleted the failures of web in dev")
+	}
+}
+
+func TestDeleteKeepsSameNamedApp(t *testing.T) {
+	defer func(v bool) { *appScrapeTiming = v }(*appScrapeTiming)
+	*appScrapeTiming = true
+	m := newMonitorState()
+	m.spaces = map[string]spaceInfo{
+		"space-dev":  {Name: "dev", OrgName: "acme"},
+		"space-prod": {Name: "prod", OrgName: "acme"},
+	}
+	dev := cfclient.App{Guid: "guid-hist-dev", Name: "web", SpaceGuid: "space-dev"}
+	prod := cfclient.App{Guid: "guid-hist-prod", Name: "web", SpaceGuid: "space-prod"}
+	for _, app := range []cfclient.App{dev, prod} {
+		m.record(app, testStats("RUNNING"), false, nil)
+		m.observeFetch(app, time.Second)
+	}
+	defer m.forget(prod.Guid)
+
+	m.forget(dev.Guid)
+	if hasSeries(appScrapeHistogram, map[string]string{"app_guid": dev.Guid}) {
+		t.Error("app_scrape_duration_seconds of the deleted app still exported")
+	}
+	if !hasSeries(appScrapeHistogram, map[string]string{"space": "prod", "app": "web", "app_guid": prod.Guid}) {
+		t.Error("app_scrape_duration_seconds of a same named app in another space deleted")
+	}
+}
+
+func TestCPUP95DroppedWithoutInstances(t *testing.T) {
+	defer func(v bool) { *appCPUP95 = v }(*appCPUP95)
+	*appCPUP95 = true
+	m := newMonitorState()
+	m.spaces = map[string]spaceInfo{"space-dev": {Name: "dev", OrgName: "acme"}}
+	app := cfclient.App{Guid: "guid-p95", Name: "web", SpaceGuid: "space-dev"}
+	defer m.forget(app.Guid)
+	labels := map[string]string{"app_guid": app.Guid}
+
+	m.record(app, testStats("RUNNING", "RUNNING"), false, nil)
+	if !hasSeries(appCPUP95Gauge, labels) {
+		t.Fatal("app_cpu_p95 not exported for a running app")
+	}
+	m.record(app, testStats(), false, nil)
+	if hasSeries(appCPUP95Gauge, labels) {
+		t.Error("app_cpu_p95 still exported after scaling to zero")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// applyConfigJSON applies settings from a JSON object such as the one in
+// CFPROM_CONFIG. Keys are either flag names or environment variable names.
+// Flags given on the command line and variables already present in the
+// environment take precedence
+func applyConfigJSON(s string) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var settings map[string]interface{}
+	if err := dec.Decode(&settings); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for key, v := range settings {
+		value := fmt.Sprint(v)
+		if key == strings.ToUpper(key) {
+			if os.Getenv(key) == "" {
+				os.Setenv(key, value)
+			}
+			continue
+		}
+		if flag.Lookup(key) == nil {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("invalid value for %q: %v", key, err)
+		}
+	}
+	return nil
+}
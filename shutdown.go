@@ -0,0 +1,47 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests and the running
+// scrape may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// beforeExit holds functions to call on shutdown, in order
+var beforeExit []func()
+
+// handleSignals shuts down on SIGINT or SIGTERM. The server stops
+// accepting requests, the monitor is stopped after its running scrape
+// and the shutdown hooks run. exited is closed when done
+func handleSignals(srv *http.Server, stop chan<- struct{}, exited chan<- struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	fmt.Printf("Received %s, shutting down\n", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("Error shutting down HTTP server: %v\n", err)
+	}
+	select {
+	case stop <- struct{}{}:
+		fmt.Println("Monitor stopped")
+	case <-ctx.Done():
+		fmt.Println("Timed out waiting for the monitor to stop")
+	}
+	for _, f := range beforeExit {
+		f()
+	}
+	close(exited)
+}
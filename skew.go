@@ -0,0 +1,54 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var clockSkewGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "cf_clock_skew_seconds",
+		Help: "Difference between the Date header of the last CF API response and the local time, positive when the CF API is ahead",
+	})
+
+// lastSkew holds the last observed clock skew in nanoseconds, and
+// skewSeen whether any was observed
+var (
+	lastSkew int64
+	skewSeen int32
+)
+
+// clockSkewTransport records the clock skew between cfprom and the CF API
+// from the Date header of the responses
+type clockSkewTransport struct {
+	next http.RoundTripper
+}
+
+func (t *clockSkewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		// Compare against the middle of the round trip to cancel out latency
+		local := start.Add(time.Since(start) / 2)
+		atomic.StoreInt64(&lastSkew, int64(date.Sub(local)))
+		atomic.StoreInt32(&skewSeen, 1)
+	}
+	return resp, nil
+}
+
+// updateClockSkew exports the last observed clock skew
+func updateClockSkew() {
+	if atomic.LoadInt32(&skewSeen) == 1 {
+		clockSkewGauge.Set(time.Duration(atomic.LoadInt64(&lastSkew)).Seconds())
+	}
+}
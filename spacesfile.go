@@ -0,0 +1,86 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// readSpacesFile returns the space GUIDs listed in path, one or more
+// comma separated per line. Lines starting with # are ignored
+func readSpacesFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var guids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		guids = append(guids, splitList(line)...)
+	}
+	return guids, nil
+}
+
+// watchSpacesFile checks path every interval and reconfigures the monitor
+// when the listed spaces change. A change is only applied once the file
+// was the same for two checks in a row, so a burst of edits results in a
+// single reconfiguration. An empty file keeps the current spaces.
+// fallback is used until a configuration is active.
+//
+// The file is polled rather than watched with inotify since a mounted
+// ConfigMap is updated by swapping a symlink of its directory, which a
+// watch on the file itself does not report
+func watchSpacesFile(ch chan config, path string, interval time.Duration, fallback config, current []string) {
+	var pending []string
+	empty := false
+	for range time.Tick(interval) {
+		guids, err := readSpacesFile(path)
+		if err != nil {
+			fmt.Printf("Error reading spaces file: %v\n", err)
+			continue
+		}
+		if len(guids) == 0 {
+			if !empty {
+				fmt.Println("Spaces file lists no spaces, keeping the current spaces")
+			}
+			empty, pending = true, nil
+			continue
+		}
+		empty = false
+		if sameList(guids, current) {
+			pending = nil
+			continue
+		}
+		if pending == nil || !sameList(guids, pending) {
+			pending = guids // Wait for the file to settle
+			continue
+		}
+		c := getActiveConfig()
+		if !c.hasCredentials() {
+			c = fallback
+		}
+		c.SpaceIDs = guids
+		fmt.Printf("Spaces file changed, monitoring %s\n", strings.Join(guids, ","))
+		sendConfig(ch, c)
+		current, pending = guids, nil
+	}
+}
+
+func sameList(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
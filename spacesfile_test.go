@@ -0,0 +1,43 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchSpacesFileKeepsSpacesWhenEmptied(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spaces")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("space-a\n")
+	ch := make(chan config, 1)
+	interval := 5 * time.Millisecond
+	go watchSpacesFile(ch, path, interval, config{}, []string{"space-a"})
+
+	write("# being rewritten\n")
+	select {
+	case c := <-ch:
+		t.Fatalf("reconfigured to %v by an empty spaces file", c.SpaceIDs)
+	case <-time.After(20 * interval):
+	}
+
+	write("space-b, space-c\n")
+	select {
+	case c := <-ch:
+		if want := []string{"space-b", "space-c"}; !reflect.DeepEqual(c.SpaceIDs, want) {
+			t.Errorf("spaces = %v, want %v", c.SpaceIDs, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reconfiguration after listing new spaces")
+	}
+}
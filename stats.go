@@ -0,0 +1,66 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// fetchAppStats retrieves the instance stats of an app, decoding the
+// response one instance at a time. When limit is positive at most
+// limit instances are decoded and truncated reports whether more were present.
+// A positive timeout bounds the request including reading the response
+func fetchAppStats(client *cfclient.Client, guid string, limit int, timeout time.Duration) (stats map[string]cfclient.AppStats, truncated bool, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v2/apps/%s/stats", client.Config.ApiAddress, guid), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, false, fmt.Errorf("unexpected app stats response for %s", guid)
+	}
+	stats = make(map[string]cfclient.AppStats)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected app stats key for %s", guid)
+		}
+		if limit > 0 && len(stats) >= limit {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, false, err
+			}
+			truncated = true
+			continue
+		}
+		var s cfclient.AppStats
+		if err := dec.Decode(&s); err != nil {
+			return nil, false, err
+		}
+		stats[key] = s
+	}
+	return stats, truncated, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// active holds the CF client and configuration currently used by the
+// monitor, for use by the HTTP handlers
+var active struct {
+	sync.RWMutex
+	client       *cfclient.Client
+	config       config
+	spaces       []spaceResponse
+	apps         int
+	bootstrapped bool
+}
+
+func setActive(client *cfclient.Client, c config) {
+	active.Lock()
+	active.client = client
+	active.config = c
+	active.Unlock()
+}
+
+// setDiscovered records the spaces and number of apps found by the last
+// discovery. The spaces are copied as the monitor keeps updating them
+func setDiscovered(spaces map[string]spaceInfo, apps int) {
+	list := make([]spaceResponse, 0, len(spaces))
+	for guid, info := range spaces {
+		list = append(list, spaceResponse{GUID: guid, Name: info.Name, OrgGUID: info.OrgGUID, OrgName: info.OrgName})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GUID < list[j].GUID })
+	active.Lock()
+	active.spaces = list
+	active.apps = apps
+	active.Unlock()
+}
+
+func setBootstrapped() {
+	active.Lock()
+	active.bootstrapped = true
+	active.Unlock()
+}
+
+func isBootstrapped() bool {
+	active.RLock()
+	defer active.RUnlock()
+	return active.bootstrapped
+}
+
+func getActiveClient() *cfclient.Client {
+	active.RLock()
+	defer active.RUnlock()
+	return active.client
+}
+
+func getActiveConfig() config {
+	active.RLock()
+	defer active.RUnlock()
+	return active.config
+}
+
+// spaceResponse describes a monitored space and its org
+type spaceResponse struct {
+	GUID    string `json:"guid"`
+	Name    string `json:"name"`
+	OrgGUID string `json:"org_guid"`
+	OrgName string `json:"org_name"`
+}
+
+// configResponse describes the effective configuration. It must never
+// contain passwords, secrets or tokens
+type configResponse struct {
+	APIAddress   string            `json:"api_address"`
+	Username     string            `json:"username"`
+	ClientID     string            `json:"client_id,omitempty"`
+	SpaceID      string            `json:"space_guid"`
+	SpaceIDs     []string          `json:"space_guids,omitempty"`
+	AppID        string            `json:"app_guid"`
+	AllApps      bool              `json:"all_apps"`
+	IncludeOrgs  []string          `json:"include_orgs"`
+	ExcludeOrgs  []string          `json:"exclude_orgs"`
+	SystemOrgs   []string          `json:"system_orgs"`
+	PriorityApps []string          `json:"priority_apps"`
+	Intervals    map[string]string `json:"intervals"`
+	BatchSize    int               `json:"batch_size"`
+	Features     []string          `json:"features"`
+	AuthEnabled  bool              `json:"auth_enabled"`
+	LoggedIn     bool              `json:"logged_in"`
+	Bootstrapped bool              `json:"bootstrapped"`
+	Spaces       []spaceResponse   `json:"spaces"`
+	Apps         int               `json:"apps"`
+}
+
+// configHandler returns the effective configuration with secrets redacted
+func configHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := getActiveConfig()
+		active.RLock()
+		spaces, apps := active.spaces, active.apps
+		active.RUnlock()
+		resp := configResponse{
+			APIAddress:   c.Config.ApiAddress,
+			Username:     c.Config.Username,
+			ClientID:     c.Config.ClientID,
+			SpaceID:      c.SpaceID,
+			SpaceIDs:     c.SpaceIDs,
+			AppID:        c.AppID,
+			AllApps:      c.AllApps,
+			IncludeOrgs:  c.IncludeOrgs,
+			ExcludeOrgs:  c.ExcludeOrgs,
+			SystemOrgs:   c.SystemOrgs,
+			PriorityApps: c.PriorityApps,
+			Intervals:    make(map[string]string),
+			BatchSize:    *batchSize,
+			Features:     splitList(features.String()),
+			AuthEnabled:  len(metricsPasswords.get()) > 0,
+			LoggedIn:     getActiveClient() != nil,
+			Bootstrapped: isBootstrapped(),
+			Spaces:       spaces,
+			Apps:         apps,
+		}
+		for group, d := range intervals {
+			resp.Intervals[group] = d.String()
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
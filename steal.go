@@ -0,0 +1,43 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cpuStealGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "instance_cpu_steal_ratio",
+		Help: "Approximated CPU contention: share of the CPU used on the cell of an instance consumed by other monitored instances",
+	},
+	[]string{"org", "space", "app", "app_guid", "instance_index"})
+
+// cellSample is the CPU usage of one instance on a cell
+type cellSample struct {
+	labels appLabels
+	guid   string
+	index  string
+	host   string
+	cpu    float64
+}
+
+// updateCPUSteal approximates CPU steal per instance. CF does not
+// report steal, so the CPU usage of co-located monitored instances is
+// used as a proxy for the contention an instance experiences
+func updateCPUSteal(samples []cellSample) {
+	perHost := make(map[string]float64)
+	for _, s := range samples {
+		perHost[s.host] += s.cpu
+	}
+	cpuStealGauge.Reset()
+	for _, s := range samples {
+		if s.host == "" || perHost[s.host] == 0 {
+			continue
+		}
+		ratio := (perHost[s.host] - s.cpu) / perHost[s.host]
+		cpuStealGauge.WithLabelValues(s.labels.Org, s.labels.Space, s.labels.App, s.guid, s.index).Set(ratio)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// appSummary is the current state of one monitored app
+type appSummary struct {
+	Org       string         `json:"org"`
+	Space     string         `json:"space"`
+	App       string         `json:"app"`
+	AppGUID   string         `json:"app_guid"`
+	Instances int            `json:"instances"`
+	CPU       float64        `json:"cpu"`
+	Memory    float64        `json:"memory_bytes"`
+	States    map[string]int `json:"states"`
+}
+
+// summarize returns the monitored apps with their total CPU and memory
+// usage and the number of instances per state, sorted by org, space and app
+func summarize() []appSummary {
+	apps := make(map[string]*appSummary)
+	get := func(labels map[string]string) *appSummary {
+		guid := labels["app_guid"]
+		s, ok := apps[guid]
+		if !ok {
+			s = &appSummary{Org: labels["org"], Space: labels["space"], App: labels["app"], AppGUID: guid, States: make(map[string]int)}
+			apps[guid] = s
+		}
+		return s
+	}
+	for _, a := range usage.snapshot() {
+		s := get(map[string]string{"org": a.Org, "space": a.Space, "app": a.App, "app_guid": a.GUID})
+		for _, u := range a.Instances {
+			s.CPU += u.CPU
+			s.Memory += u.Mem
+		}
+	}
+	for _, m := range collectMetrics(instanceStateGauge) {
+		labels := labelMap(m)
+		s := get(labels)
+		s.Instances++
+		s.States[labels["state"]]++
+	}
+	list := make([]appSummary, 0, len(apps))
+	for _, s := range apps {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Org != b.Org {
+			return a.Org < b.Org
+		}
+		if a.Space != b.Space {
+			return a.Space < b.Space
+		}
+		if a.App != b.App {
+			return a.App < b.App
+		}
+		return a.AppGUID < b.AppGUID
+	})
+	return list
+}
+
+// collectMetrics returns the current samples of c
+func collectMetrics(c prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var metrics []*dto.Metric
+	for m := range ch {
+		var d dto.Metric
+		if err := m.Write(&d); err == nil {
+			metrics = append(metrics, &d)
+		}
+	}
+	return metrics
+}
+
+func labelMap(m *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(m.Label))
+	for _, l := range m.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	return labels
+}
+
+// summaryHandler returns the summary of the monitored apps as JSON
+func summaryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, summarize())
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// syntheticName labels all injected series so they can't be
+// mistaken for real data
+const syntheticName = "__synthetic__"
+
+type injectRequest struct {
+	InstanceIndex string  `json:"instance_index"`
+	CPU           float64 `json:"cpu"`
+	Mem           float64 `json:"mem"`
+}
+
+// injectHandler sets arbitrary CPU and memory values for a fake app
+// so alerting rules can be verified end-to-end
+func injectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var i injectRequest
+		defer req.Body.Close()
+		if err := json.NewDecoder(req.Body).Decode(&i); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if i.InstanceIndex == "" {
+			i.InstanceIndex = "0"
+		}
+		labels := appLabels{Org: syntheticName, Space: syntheticName, App: syntheticName}
+		usage.setInstance(labels, syntheticName, i.InstanceIndex, instanceUsage{CPU: i.CPU, Mem: i.Mem}, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
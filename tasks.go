@@ -0,0 +1,89 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// taskRetention is how long finished tasks keep being reported
+const taskRetention = time.Hour
+
+var (
+	taskStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cf_task_state",
+			Help: "Current state of a task, set to 1 for the state it is in",
+		},
+		[]string{"org", "space", "app", "task", "task_guid", "state"})
+	taskDurationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cf_task_duration_seconds",
+			Help: "Run time of a task, up to now for tasks that did not finish yet",
+		},
+		[]string{"org", "space", "app", "task", "task_guid"})
+)
+
+func isFinishedTask(state string) bool {
+	return state == "SUCCEEDED" || state == "FAILED"
+}
+
+// updateTasks exports the state and duration of the recent tasks of apps.
+// Series of tasks no longer reported are removed
+func (m *monitorState) updateTasks() error {
+	// Query by app rather than by space, so that in all-apps mode the
+	// tasks of the whole foundation are not listed
+	var tasks []cfclient.Task
+	byGUID := make(map[string]cfclient.App, len(m.apps))
+	guids := make([]string, 0, len(m.apps))
+	for _, app := range m.apps {
+		byGUID[app.Guid] = app
+		guids = append(guids, app.Guid)
+	}
+	for start := 0; start < len(guids); start += *batchSize {
+		end := start + *batchSize
+		if end > len(guids) {
+			end = len(guids)
+		}
+		q := url.Values{}
+		q.Set("order_by", "-created_at")
+		q.Set("per_page", "5000")
+		q.Set("app_guids", strings.Join(guids[start:end], ","))
+		batch, err := m.client.ListTasksByQuery(q)
+		if err != nil {
+			return fmt.Errorf("listing tasks: %v", err)
+		}
+		tasks = append(tasks, batch...)
+	}
+
+	taskStateGauge.Reset()
+	taskDurationGauge.Reset()
+	now := time.Now()
+	for _, task := range tasks {
+		finished := isFinishedTask(task.State)
+		if finished && now.Sub(task.UpdatedAt) > taskRetention {
+			continue
+		}
+		app, ok := byGUID[path.Base(task.Links.App.Href)]
+		if !ok {
+			continue
+		}
+		l := m.labelsFor(app)
+		taskStateGauge.WithLabelValues(l.Org, l.Space, l.App, task.Name, task.GUID, task.State).Set(1)
+		end := now
+		if finished {
+			end = task.UpdatedAt
+		}
+		taskDurationGauge.WithLabelValues(l.Org, l.Space, l.App, task.Name, task.GUID).Set(end.Sub(task.CreatedAt).Seconds())
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestUpdateTasksQueriesMonitoredApps(t *testing.T) {
+	defer func(n int) { *batchSize = n }(*batchSize)
+	*batchSize = 2
+	var queried []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		guids := req.URL.Query().Get("app_guids")
+		queried = append(queried, guids)
+		var tasks []string
+		for _, guid := range strings.Split(guids, ",") {
+			tasks = append(tasks, fmt.Sprintf(`{"guid":"task-%s","name":"migrate","state":"RUNNING","created_at":%q,"links":{"app":{"href":"/v3/apps/%s"}}}`,
+				guid, time.Now().Add(-time.Minute).Format(time.RFC3339), guid))
+		}
+		fmt.Fprintf(w, `{"pagination":{"total_results":%d,"total_pages":1},"resources":[%s]}`, len(tasks), strings.Join(tasks, ","))
+	}))
+	defer srv.Close()
+
+	m := newMonitorState()
+	m.client = &cfclient.Client{Config: cfclient.Config{ApiAddress: srv.URL, HttpClient: srv.Client()}}
+	m.activeConfig.AllApps = true // No space filter
+	m.spaces = map[string]spaceInfo{"space-dev": {Name: "dev", OrgName: "acme"}}
+	for _, guid := range []string{"guid-a", "guid-b", "guid-c"} {
+		m.apps = append(m.apps, cfclient.App{Guid: guid, Name: guid, SpaceGuid: "space-dev"})
+	}
+	defer taskStateGauge.Reset()
+	if err := m.updateTasks(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"guid-a,guid-b", "guid-c"}; strings.Join(queried, ";") != strings.Join(want, ";") {
+		t.Errorf("queried app_guids %q, want %q", queried, want)
+	}
+	for _, app := range m.apps {
+		if !hasSeries(taskStateGauge, map[string]string{"app": app.Name, "task_guid": "task-" + app.Guid}) {
+			t.Errorf("cf_task_state of %s missing", app.Name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// textDump writes all metrics to a file in the Prometheus text format
+type textDump struct {
+	path     string
+	gatherer prometheus.Gatherer
+}
+
+// write replaces the dump file with the current metrics
+func (d *textDump) write() {
+	if err := d.writeFile(); err != nil {
+		fmt.Printf("Error writing text dump: %v\n", err)
+	}
+}
+
+// writeFile writes the metrics to a temporary file next to the dump
+// file and renames it, so readers never see a partial dump
+func (d *textDump) writeFile() error {
+	mfs, err := d.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), "."+filepath.Base(d.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(tmp, mf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTextDumpTimestamps(t *testing.T) {
+	defer atomic.StoreInt64(&lastScrape, atomic.LoadInt64(&lastScrape))
+	scraped := time.Unix(1500000000, 0)
+	atomic.StoreInt64(&lastScrape, scraped.UnixNano())
+
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_dumped", Help: "Dumped to a file"})
+	g.Set(42)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(g)
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+
+	for _, tt := range []struct {
+		gatherer prometheus.Gatherer
+		want     string
+	}{
+		{registry, "test_dumped 42\n"},
+		{timestampGatherer{registry}, "test_dumped 42 1500000000000\n"},
+	} {
+		d := &textDump{path, tt.gatherer}
+		if err := d.writeFile(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(data), tt.want) {
+			t.Errorf("dump = %q, want it to end in %q", data, tt.want)
+		}
+	}
+}
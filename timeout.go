@@ -0,0 +1,57 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeBudget is the fraction of the stats interval a scrape should take
+const scrapeBudget = 0.8
+
+var appTimeoutGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "cfprom_app_timeout_seconds",
+		Help: "Effective timeout of fetching the stats of one app",
+	})
+
+// appTimeout adapts the per app stats timeout to the scrape duration.
+// A zero max disables the timeout
+type appTimeout struct {
+	current, min, max time.Duration
+}
+
+func newAppTimeout(min, max time.Duration) *appTimeout {
+	t := &appTimeout{current: max, min: min, max: max}
+	appTimeoutGauge.Set(t.current.Seconds())
+	return t
+}
+
+// adjust shortens the timeout in proportion when a scrape took longer than
+// its budget of interval and lengthens it by a quarter when a scrape took
+// less than half of the budget. The timeout stays between min and max
+func (t *appTimeout) adjust(took, interval time.Duration) {
+	if t.max == 0 || took <= 0 {
+		return
+	}
+	budget := time.Duration(scrapeBudget * float64(interval))
+	switch {
+	case took > budget:
+		t.current = time.Duration(float64(t.current) * float64(budget) / float64(took))
+	case took < budget/2:
+		t.current += t.current / 4
+	default:
+		return
+	}
+	if t.current < t.min {
+		t.current = t.min
+	}
+	if t.current > t.max {
+		t.current = t.max
+	}
+	appTimeoutGauge.Set(t.current.Seconds())
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsFamilies holds the names of the metrics set from the cached stats
+// of an app. Their samples are timestamped with the time these stats
+// were fetched rather than with the last scrape
+var statsFamilies = map[string]bool{}
+
+// timestampGatherer attaches the time the values were collected to every
+// sample gathered from the wrapped Gatherer. Before the first scrape
+// samples are left without a timestamp
+type timestampGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g timestampGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	var last time.Time
+	if atomic.LoadInt64(&lastScrape) != 0 {
+		last = lastScraped()
+	}
+	updated := usage.updated()
+	for _, mf := range mfs {
+		perApp := statsFamilies[mf.GetName()]
+		for _, m := range mf.Metric {
+			t := last
+			if perApp {
+				if u, ok := updated[labelMap(m)["app_guid"]]; ok {
+					t = u
+				}
+			}
+			if t.IsZero() {
+				continue
+			}
+			ts := t.UnixNano() / 1e6
+			m.TimestampMs = &ts
+		}
+	}
+	return mfs, err
+}
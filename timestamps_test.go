@@ -0,0 +1,61 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTimestampGatherer(t *testing.T) {
+	defer atomic.StoreInt64(&lastScrape, atomic.LoadInt64(&lastScrape))
+	atomic.StoreInt64(&lastScrape, 0)
+
+	fetched := time.Unix(1500000000, 0)
+	usage.set(appLabels{Org: "acme", Space: "dev", App: "web"}, "guid-ts", map[string]instanceUsage{"0": {CPU: 1}}, fetched)
+	defer usage.delete("guid-ts")
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_other", Help: "Not set from stats"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(usage, other)
+	g := timestampGatherer{registry}
+
+	timestamps := func() map[string]int64 {
+		mfs, err := g.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]int64)
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				if labelMap(m)["app_guid"] == "guid-ts" || mf.GetName() == "test_other" {
+					got[mf.GetName()] = m.GetTimestampMs()
+				}
+			}
+		}
+		return got
+	}
+
+	// Before the first scrape only the cached stats have a time
+	got := timestamps()
+	if want := fetched.UnixNano() / 1e6; got["cpu_usage"] != want {
+		t.Errorf("cpu_usage timestamp = %d, want %d", got["cpu_usage"], want)
+	}
+	if got["test_other"] != 0 {
+		t.Errorf("test_other timestamp = %d before the first scrape, want none", got["test_other"])
+	}
+
+	scraped := fetched.Add(time.Minute)
+	markScraped(scraped)
+	got = timestamps()
+	if want := fetched.UnixNano() / 1e6; got["cpu_usage"] != want {
+		t.Errorf("cpu_usage timestamp = %d, want the fetch time %d", got["cpu_usage"], want)
+	}
+	if want := scraped.UnixNano() / 1e6; got["test_other"] != want {
+		t.Errorf("test_other timestamp = %d, want the scrape time %d", got["test_other"], want)
+	}
+}
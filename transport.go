@@ -0,0 +1,90 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// transportOptions configure the HTTP transport used for the CF API
+type transportOptions struct {
+	CAFile              string
+	SkipSSLValidation   bool
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	Timeout             time.Duration
+}
+
+// newCFHTTPClient returns the HTTP client used to talk to the CF API.
+// When CAFile is set its PEM certificates are the trusted roots.
+// cfclient only applies SkipSslValidation to a bare *http.Transport,
+// so it is set on the wrapped transport here
+func newCFHTTPClient(opts transportOptions) (*http.Client, error) {
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	transport := &http.Transport{
+		Proxy:                 defaultTransport.Proxy,
+		TLSHandshakeTimeout:   defaultTransport.TLSHandshakeTimeout,
+		ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: opts.SkipSSLValidation},
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+	}
+	if caFile := opts.CAFile; caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	timeout := &timeoutTransport{next: transport, timeout: opts.Timeout}
+	return &http.Client{Transport: &rateLimitTransport{next: &clockSkewTransport{next: timeout}}}, nil
+}
+
+// timeoutTransport bounds every CF API call, including reading the
+// response body, so a stalled CF API cannot block the monitor. It sits
+// in the transport as cfclient replaces the http.Client when logging in
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody releases the timeout of a call once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Andy Lo-A-Foe. All rights reserved.
+// Use of this source code is governed by Apache-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instanceUsage is the last reported usage of one instance
+type instanceUsage struct {
+	CPU   float64
+	Mem   float64
+	Disk  float64
+	State string
+}
+
+// appUsage is the last reported usage of all instances of an app
+type appUsage struct {
+	appLabels
+	GUID      string
+	Instances map[string]instanceUsage
+	Updated   time.Time
+}
+
+// usageCollector serves the CPU, memory and disk usage cached by the
+// monitor, computing their age when Prometheus scrapes instead of
+// when the CF API was polled
+type usageCollector struct {
+	cpu  *prometheus.Desc
+	mem  *prometheus.Desc
+	disk *prometheus.Desc
+	age  *prometheus.Desc
+
+	// gauges, when set, receive the cached usage instead of the
+	// collector being registered
+	gauges *usageGauges
+
+	mu   sync.RWMutex
+	apps map[string]appUsage
+}
+
+// usageGauges export the cached usage as plain gauges, used unless the
+// collector-model feature is enabled
+type usageGauges struct {
+	cpu  *prometheus.GaugeVec
+	mem  *prometheus.GaugeVec
+	disk *prometheus.GaugeVec
+}
+
+func newUsageGauges(namespace string) *usageGauges {
+	labels := []string{"org", "space", "app", "app_guid", "instance_index"}
+	return &usageGauges{
+		cpu:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: "cpu_usage", Help: "CPU usage"}, labels),
+		mem:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: "mem_usage", Help: "Memory usage"}, labels),
+		disk: prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: "disk_usage", Help: "Disk usage"}, labels),
+	}
+}
+
+// update sets the gauges of the instances of a and deletes those of
+// prev which a no longer has
+func (g *usageGauges) update(prev, a appUsage) {
+	for i := range prev.Instances {
+		if _, ok := a.Instances[i]; !ok || prev.appLabels != a.appLabels {
+			g.cpu.DeleteLabelValues(prev.Org, prev.Space, prev.App, prev.GUID, i)
+			g.mem.DeleteLabelValues(prev.Org, prev.Space, prev.App, prev.GUID, i)
+			g.disk.DeleteLabelValues(prev.Org, prev.Space, prev.App, prev.GUID, i)
+		}
+	}
+	for i, u := range a.Instances {
+		g.cpu.WithLabelValues(a.Org, a.Space, a.App, a.GUID, i).Set(u.CPU)
+		g.mem.WithLabelValues(a.Org, a.Space, a.App, a.GUID, i).Set(u.Mem)
+		g.disk.WithLabelValues(a.Org, a.Space, a.App, a.GUID, i).Set(u.Disk)
+	}
+}
+
+func newUsageCollector(namespace string) *usageCollector {
+	labels := []string{"org", "space", "app", "app_guid", "instance_index"}
+	return &usageCollector{
+		cpu:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "cpu_usage"), "CPU usage", labels, nil),
+		mem:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "mem_usage"), "Memory usage", labels, nil),
+		disk: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "disk_usage"), "Disk usage", labels, nil),
+		age: prometheus.NewDesc("cfprom_app_stats_age_seconds",
+			"Seconds since the usage of an app was last fetched successfully",
+			[]string{"org", "space", "app", "app_guid"}, nil),
+		apps: make(map[string]appUsage),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpu
+	ch <- c.mem
+	ch <- c.disk
+	ch <- c.age
+}
+
+// Collect implements prometheus.Collector
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, a := range c.apps {
+		ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue,
+			now.Sub(a.Updated).Seconds(), a.Org, a.Space, a.App, a.GUID)
+		for i, u := range a.Instances {
+			ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.GaugeValue, u.CPU, a.Org, a.Space, a.App, a.GUID, i)
+			ch <- prometheus.MustNewConstMetric(c.mem, prometheus.GaugeValue, u.Mem, a.Org, a.Space, a.App, a.GUID, i)
+			ch <- prometheus.MustNewConstMetric(c.disk, prometheus.GaugeValue, u.Disk, a.Org, a.Space, a.App, a.GUID, i)
+		}
+	}
+}
+
+// set replaces the cached usage of the app with guid
+func (c *usageCollector) set(labels appLabels, guid string, instances map[string]instanceUsage, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(appUsage{labels, guid, instances, now})
+}
+
+// setInstance replaces the cached usage of instance i of the app with
+// guid, keeping the other instances
+func (c *usageCollector) setInstance(labels appLabels, guid, i string, u instanceUsage, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	instances := map[string]instanceUsage{i: u}
+	for k, v := range c.apps[guid].Instances {
+		if k != i {
+			instances[k] = v
+		}
+	}
+	c.store(appUsage{labels, guid, instances, now})
+}
+
+// store replaces the cached usage of a.GUID, which must be called with
+// mu held
+func (c *usageCollector) store(a appUsage) {
+	if c.gauges != nil {
+		c.gauges.update(c.apps[a.GUID], a)
+	}
+	c.apps[a.GUID] = a
+}
+
+// instance returns the cached usage of instance i of the app with guid
+func (c *usageCollector) instance(guid, i string) (appUsage, instanceUsage, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	a, ok := c.apps[guid]
+	if !ok {
+		return appUsage{}, instanceUsage{}, false
+	}
+	u, ok := a.Instances[i]
+	return a, u, ok
+}
+
+// delete drops the cached usage of the app with guid
+func (c *usageCollector) delete(guid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gauges != nil {
+		c.gauges.update(c.apps[guid], appUsage{})
+	}
+	delete(c.apps, guid)
+}
+
+// updated returns the time the usage of every app was last fetched
+func (c *usageCollector) updated() map[string]time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	updated := make(map[string]time.Time, len(c.apps))
+	for guid, a := range c.apps {
+		updated[guid] = a.Updated
+	}
+	return updated
+}
+
+// snapshot returns a copy of the cached usage of all apps
+func (c *usageCollector) snapshot() []appUsage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]appUsage, 0, len(c.apps))
+	for _, a := range c.apps {
+		list = append(list, a)
+	}
+	return list
+}